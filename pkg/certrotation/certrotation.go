@@ -0,0 +1,439 @@
+// Package certrotation issues and rotates the self-signed CA and serving
+// certificate the registry's HTTPS listener uses. Unlike the
+// service-ca-operator annotation already on the registry Service (which
+// only ever covers the in-cluster Service DNS name), this subsystem
+// reissues the leaf certificate with SANs for both the internal Service
+// DNS names and any hostnames configured under Spec.Routes, so a
+// custom/external Route hostname also gets a certificate clients trust.
+// It rotates the signer well before expiry and keeps the previous CA
+// around in the bundle for a grace period so in-flight clients using the
+// old leaf cert don't break mid-rotation.
+package certrotation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+const (
+	// SigningSecretName holds the operator's self-signed CA key pair.
+	SigningSecretName = "image-registry-ca"
+	// ServingSecretName holds the leaf certificate the registry
+	// container's REGISTRY_HTTP_TLS_CERTIFICATE/_KEY point at.
+	ServingSecretName = "image-registry-serving-cert"
+	// CABundleConfigMapName holds every CA clients should trust: the
+	// current signer plus, during a rotation's grace period, the one it
+	// replaced.
+	CABundleConfigMapName = "image-registry-ca-bundle"
+
+	signerCommonName  = "image-registry-operator-ca"
+	servingCommonName = "image-registry"
+
+	defaultSigningCertValidity = 2 * 365 * 24 * time.Hour
+	defaultServingCertValidity = 60 * 24 * time.Hour
+
+	// caBundleGracePeriod is how long a CA stays in the bundle after its
+	// signer has been rotated out, so certs it already signed keep
+	// validating until they themselves are replaced.
+	caBundleGracePeriod = defaultServingCertValidity
+
+	caBundleDataKey       = "ca-bundle.crt"
+	tlsCertDataKey        = corev1.TLSCertKey
+	tlsKeyDataKey         = corev1.TLSPrivateKeyKey
+	rotatedFromAnnotation = "imageregistry.operator.openshift.io/rotated-from"
+)
+
+// signingCA is the signer's certificate paired with the private key
+// needed to sign new leaf certificates; the key never leaves this
+// package.
+type signingCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// Reconcile ensures the signing CA, serving certificate and CA bundle
+// for namespace all exist and are within their validity window, rotating
+// whichever of them needs it. It reports whether anything changed so
+// callers can fold that into the *bool they thread through the rest of
+// reconciliation. If cr.Spec.TLS.CustomCA names an externally-managed
+// secret, Reconcile does nothing: the cluster admin owns rotation in
+// that case.
+func Reconcile(cr *regopapi.ImageRegistry, p *parameters.Globals, modified *bool) error {
+	if cr.Spec.TLS != nil && cr.Spec.TLS.CustomCA != nil && cr.Spec.TLS.CustomCA.SecretName != "" {
+		return nil
+	}
+
+	namespace := p.Deployment.Namespace
+
+	signingRefreshBefore := refreshBeforeFor(cr, defaultSigningCertValidity)
+	signer, rotated, err := ensureSigningCertKeyPair(namespace, signingRefreshBefore)
+	if err != nil {
+		return fmt.Errorf("unable to ensure signing certificate: %s", err)
+	}
+	if rotated {
+		*modified = true
+	}
+
+	if err := ensureCABundleConfigMap(namespace, signer.cert, rotated); err != nil {
+		return fmt.Errorf("unable to ensure CA bundle: %s", err)
+	}
+
+	dnsNames := servingDNSNames(cr, p)
+	servingRefreshBefore := refreshBeforeFor(cr, defaultServingCertValidity)
+	servingRotated, err := ensureServingCertKeyPair(namespace, signer, dnsNames, servingRefreshBefore, rotated)
+	if err != nil {
+		return fmt.Errorf("unable to ensure serving certificate: %s", err)
+	}
+	if servingRotated {
+		*modified = true
+	}
+
+	return nil
+}
+
+// refreshBeforeFor returns how long before expiry a certificate with the
+// given validity should be reissued: cr.Spec.TLS.Rotation.RefreshBefore
+// if set, otherwise 20% of validity, i.e. refresh once 80% of its
+// lifetime has elapsed.
+func refreshBeforeFor(cr *regopapi.ImageRegistry, validity time.Duration) time.Duration {
+	if cr.Spec.TLS != nil && cr.Spec.TLS.Rotation != nil && cr.Spec.TLS.Rotation.RefreshBefore != "" {
+		if d, err := time.ParseDuration(cr.Spec.TLS.Rotation.RefreshBefore); err == nil {
+			return d
+		}
+	}
+	return validity / 5
+}
+
+// servingDNSNames is every hostname the serving certificate needs a SAN
+// for: the internal Service DNS name in all the forms clients resolve it
+// by, plus every hostname the operator has been asked to expose a Route
+// for.
+func servingDNSNames(cr *regopapi.ImageRegistry, p *parameters.Globals) []string {
+	names := []string{
+		p.Service.Name,
+		fmt.Sprintf("%s.%s", p.Service.Name, p.Deployment.Namespace),
+		fmt.Sprintf("%s.%s.svc", p.Service.Name, p.Deployment.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", p.Service.Name, p.Deployment.Namespace),
+	}
+
+	for _, rc := range cr.Spec.Routes {
+		if rc.Hostname != "" {
+			names = append(names, rc.Hostname)
+		}
+	}
+	names = append(names, cr.Status.ExternalRegistryHostnames...)
+
+	return names
+}
+
+func needsRotation(cert *x509.Certificate, refreshBefore time.Duration) bool {
+	return !time.Now().Before(cert.NotAfter.Add(-refreshBefore))
+}
+
+// ensureSigningCertKeyPair loads the signing Secret, generating or
+// rotating it as needed, and returns the CA's certificate and private
+// key so the caller can sign the serving certificate with it.
+func ensureSigningCertKeyPair(namespace string, refreshBefore time.Duration) (*signingCA, bool, error) {
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: SigningSecretName, Namespace: namespace},
+	}
+
+	err := sdk.Get(secret)
+	switch {
+	case err == nil:
+		if ca, parseErr := parseCA(secret.Data[tlsCertDataKey], secret.Data[tlsKeyDataKey]); parseErr == nil {
+			if !needsRotation(ca.cert, refreshBefore) {
+				return ca, false, nil
+			}
+		}
+	case errors.IsNotFound(err):
+		// first run, fall through to create it
+	default:
+		return nil, false, fmt.Errorf("unable to get signing secret %s: %s", SigningSecretName, err)
+	}
+
+	ca, certPEM, keyPEM, err := newSelfSignedCA(signerCommonName, defaultSigningCertValidity)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to generate signing certificate: %s", err)
+	}
+
+	create := secret.ResourceVersion == ""
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{tlsCertDataKey: certPEM, tlsKeyDataKey: keyPEM}
+
+	if create {
+		err = sdk.Create(secret)
+	} else {
+		err = sdk.Update(secret)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to persist signing secret %s: %s", SigningSecretName, err)
+	}
+
+	return ca, true, nil
+}
+
+// ensureServingCertKeyPair loads the serving Secret, (re)issuing it
+// whenever it is missing, nearing expiry, its SANs no longer match
+// dnsNames, or the signer itself was just rotated.
+func ensureServingCertKeyPair(namespace string, signer *signingCA, dnsNames []string, refreshBefore time.Duration, signerRotated bool) (bool, error) {
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: ServingSecretName, Namespace: namespace},
+	}
+
+	err := sdk.Get(secret)
+	switch {
+	case err == nil:
+		if !signerRotated {
+			if cert, parseErr := parseCertPEM(secret.Data[tlsCertDataKey]); parseErr == nil {
+				if !needsRotation(cert, refreshBefore) && sameDNSNames(cert.DNSNames, dnsNames) {
+					return false, nil
+				}
+			}
+		}
+	case errors.IsNotFound(err):
+		// first run, fall through to create it
+	default:
+		return false, fmt.Errorf("unable to get serving secret %s: %s", ServingSecretName, err)
+	}
+
+	certPEM, keyPEM, err := newSignedCert(signer, servingCommonName, dnsNames, defaultServingCertValidity)
+	if err != nil {
+		return false, fmt.Errorf("unable to generate serving certificate: %s", err)
+	}
+
+	create := secret.ResourceVersion == ""
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{tlsCertDataKey: certPEM, tlsKeyDataKey: keyPEM}
+
+	if create {
+		err = sdk.Create(secret)
+	} else {
+		err = sdk.Update(secret)
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to persist serving secret %s: %s", ServingSecretName, err)
+	}
+
+	return true, nil
+}
+
+// ensureCABundleConfigMap keeps the bundle in sync with signer. When the
+// signer was just rotated, the new signer is appended to the bundle's
+// previous contents and rotatedFromAnnotation records when, so certs the
+// old signer already signed keep validating; once caBundleGracePeriod has
+// passed since then, the bundle is pruned back to the current signer.
+func ensureCABundleConfigMap(namespace string, signer *x509.Certificate, signerRotated bool) error {
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: CABundleConfigMapName, Namespace: namespace},
+	}
+
+	err := sdk.Get(cm)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get CA bundle configmap %s: %s", CABundleConfigMapName, err)
+	}
+	create := errors.IsNotFound(err)
+
+	signerPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signer.Raw}))
+
+	bundle := signerPEM
+	switch {
+	case !create && !signerRotated:
+		if existing, ok := cm.Data[caBundleDataKey]; ok && existing != "" {
+			bundle = existing
+		}
+		if v, ok := cm.Annotations[rotatedFromAnnotation]; ok {
+			if t, parseErr := time.Parse(time.RFC3339, v); parseErr == nil && time.Since(t) >= caBundleGracePeriod {
+				bundle = signerPEM
+			}
+		}
+	case !create && signerRotated:
+		bundle = cm.Data[caBundleDataKey] + signerPEM
+	}
+
+	if !create && cm.Data[caBundleDataKey] == bundle {
+		return nil
+	}
+
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	if signerRotated {
+		cm.Annotations[rotatedFromAnnotation] = time.Now().Format(time.RFC3339)
+	}
+	cm.Data = map[string]string{caBundleDataKey: bundle}
+
+	if create {
+		err = sdk.Create(cm)
+	} else {
+		err = sdk.Update(cm)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to persist CA bundle configmap %s: %s", CABundleConfigMapName, err)
+	}
+
+	return nil
+}
+
+// Checksum returns a stable digest of the signing, serving and CA bundle
+// material currently in namespace, so a Deployment/DeploymentConfig pod
+// template can carry it as an annotation and roll out whenever any of
+// them rotates, the same way getSecretChecksum/getConfigMapChecksum do
+// for the storage secret and trusted-CA configmap.
+func Checksum(namespace string) (string, error) {
+	signing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: SigningSecretName, Namespace: namespace}}
+	if err := sdk.Get(signing); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get signing secret %s: %s", SigningSecretName, err)
+	}
+
+	serving := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ServingSecretName, Namespace: namespace}}
+	if err := sdk.Get(serving); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get serving secret %s: %s", ServingSecretName, err)
+	}
+
+	bundle := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: CABundleConfigMapName, Namespace: namespace}}
+	if err := sdk.Get(bundle); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get CA bundle configmap %s: %s", CABundleConfigMapName, err)
+	}
+
+	h := sha256.New()
+	h.Write(signing.Data[tlsCertDataKey])
+	h.Write(serving.Data[tlsCertDataKey])
+	h.Write([]byte(bundle.Data[caBundleDataKey]))
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func parseCertPEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseCA(certData, keyData []byte) (*signingCA, error) {
+	cert, err := parseCertPEM(certData)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingCA{cert: cert, key: key}, nil
+}
+
+func sameDNSNames(have, want []string) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	seen := make(map[string]bool, len(have))
+	for _, n := range have {
+		seen[n] = true
+	}
+	for _, n := range want {
+		if !seen[n] {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeCertPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKeyPEM(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func newSelfSignedCA(commonName string, validity time.Duration) (*signingCA, []byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &signingCA{cert: cert, key: key}, encodeCertPEM(der), encodeKeyPEM(key), nil
+}
+
+func newSignedCert(signer *signingCA, commonName string, dnsNames []string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCertPEM(der), encodeKeyPEM(key), nil
+}
@@ -0,0 +1,152 @@
+package certrotation
+
+import (
+	"testing"
+	"time"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+func TestNeedsRotation(t *testing.T) {
+	ca, _, _, err := newSelfSignedCA("test-ca", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("newSelfSignedCA returned an error: %s", err)
+	}
+
+	if needsRotation(ca.cert, time.Hour) {
+		t.Errorf("a cert with most of its validity left should not need rotation")
+	}
+	if !needsRotation(ca.cert, 23*time.Hour) {
+		t.Errorf("a cert within its refresh window should need rotation")
+	}
+}
+
+func TestRefreshBeforeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		cr   *regopapi.ImageRegistry
+		want time.Duration
+	}{
+		{
+			name: "no TLS config, defaults to a fifth of validity",
+			cr:   &regopapi.ImageRegistry{},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "explicit RefreshBefore overrides the default",
+			cr: &regopapi.ImageRegistry{Spec: regopapi.ImageRegistrySpec{
+				TLS: &regopapi.ImageRegistryConfigTLS{Rotation: &regopapi.CertRotation{RefreshBefore: "30m"}},
+			}},
+			want: 30 * time.Minute,
+		},
+		{
+			name: "unparsable RefreshBefore falls back to the default",
+			cr: &regopapi.ImageRegistry{Spec: regopapi.ImageRegistrySpec{
+				TLS: &regopapi.ImageRegistryConfigTLS{Rotation: &regopapi.CertRotation{RefreshBefore: "not-a-duration"}},
+			}},
+			want: 2 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshBeforeFor(tt.cr, 10*time.Hour); got != tt.want {
+				t.Errorf("refreshBeforeFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServingDNSNames(t *testing.T) {
+	cr := &regopapi.ImageRegistry{
+		Spec: regopapi.ImageRegistrySpec{
+			Routes: []regopapi.RouteConfig{
+				{Name: "default", Hostname: "registry.example.com"},
+				{Name: "no-hostname"},
+			},
+		},
+		Status: regopapi.ImageRegistryStatus{ExternalRegistryHostnames: []string{"registry.other.example.com"}},
+	}
+	p := testParams()
+
+	names := servingDNSNames(cr, p)
+
+	want := []string{
+		"image-registry",
+		"image-registry.openshift-image-registry",
+		"image-registry.openshift-image-registry.svc",
+		"image-registry.openshift-image-registry.svc.cluster.local",
+		"registry.example.com",
+		"registry.other.example.com",
+	}
+	if !sameDNSNames(names, want) {
+		t.Errorf("servingDNSNames() = %v, want %v", names, want)
+	}
+}
+
+func TestSameDNSNames(t *testing.T) {
+	tests := []struct {
+		name string
+		have []string
+		want []string
+		same bool
+	}{
+		{"identical", []string{"a", "b"}, []string{"a", "b"}, true},
+		{"reordered", []string{"a", "b"}, []string{"b", "a"}, true},
+		{"different length", []string{"a"}, []string{"a", "b"}, false},
+		{"different names", []string{"a", "b"}, []string{"a", "c"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameDNSNames(tt.have, tt.want); got != tt.same {
+				t.Errorf("sameDNSNames(%v, %v) = %v, want %v", tt.have, tt.want, got, tt.same)
+			}
+		})
+	}
+}
+
+// TestSignAndParseCertRoundTrip covers the PEM encode/decode path
+// ensureSigningCertKeyPair and ensureServingCertKeyPair depend on: a
+// freshly issued signing CA and a leaf it signs must both survive being
+// PEM-encoded and re-parsed with their original SANs and key material
+// intact.
+func TestSignAndParseCertRoundTrip(t *testing.T) {
+	ca, certPEM, keyPEM, err := newSelfSignedCA(signerCommonName, time.Hour)
+	if err != nil {
+		t.Fatalf("newSelfSignedCA returned an error: %s", err)
+	}
+
+	parsedCA, err := parseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("parseCA returned an error: %s", err)
+	}
+	if parsedCA.cert.Subject.CommonName != signerCommonName {
+		t.Errorf("parsed CA CommonName = %q, want %q", parsedCA.cert.Subject.CommonName, signerCommonName)
+	}
+
+	dnsNames := []string{"image-registry", "registry.example.com"}
+	leafCertPEM, _, err := newSignedCert(ca, servingCommonName, dnsNames, time.Hour)
+	if err != nil {
+		t.Fatalf("newSignedCert returned an error: %s", err)
+	}
+
+	leaf, err := parseCertPEM(leafCertPEM)
+	if err != nil {
+		t.Fatalf("parseCertPEM returned an error: %s", err)
+	}
+	if !sameDNSNames(leaf.DNSNames, dnsNames) {
+		t.Errorf("leaf DNSNames = %v, want %v", leaf.DNSNames, dnsNames)
+	}
+	if err := leaf.CheckSignatureFrom(ca.cert); err != nil {
+		t.Errorf("leaf certificate was not signed by the issuing CA: %s", err)
+	}
+}
+
+func testParams() *parameters.Globals {
+	p := &parameters.Globals{}
+	p.Deployment.Namespace = "openshift-image-registry"
+	p.Service.Name = "image-registry"
+	return p
+}
@@ -2,12 +2,15 @@ package generate
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -18,6 +21,11 @@ import (
 	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
 )
 
+// proxyConfigMapName holds the generated multi-upstream proxy config.yml
+// that is mounted over /etc/docker/registry/config.yml; see
+// generateProxyConfig.
+const proxyConfigMapName = "image-registry-proxy-config"
+
 func generateLogLevel(cr *v1alpha1.OpenShiftDockerRegistry) string {
 	switch cr.Spec.Logging.Level {
 	case 0:
@@ -58,6 +66,11 @@ func generateProbeConfig(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Glo
 	}
 }
 
+// uidRangeAnnotation is the namespace annotation the cluster's
+// openshift.io/UIDRange SCC allocator stamps every project with,
+// analogous to parameters.SupplementalGroupsAnnotation for group IDs.
+const uidRangeAnnotation = "openshift.io/sa.scc.uid-range"
+
 func generateSecurityContext(cr *v1alpha1.OpenShiftDockerRegistry, namespace string) (*corev1.PodSecurityContext, error) {
 	ns := &projectapi.Project{
 		TypeMeta: metav1.TypeMeta{
@@ -88,9 +101,116 @@ func generateSecurityContext(cr *v1alpha1.OpenShiftDockerRegistry, namespace str
 		return nil, fmt.Errorf("unable to parse annotation %s in namespace %q: %s", parameters.SupplementalGroupsAnnotation, namespace, err)
 	}
 
-	return &corev1.PodSecurityContext{
+	securityContext := &corev1.PodSecurityContext{
 		FSGroup: &gid,
-	}, nil
+	}
+
+	if cr.Spec.Security == nil || !cr.Spec.Security.Restricted {
+		return securityContext, nil
+	}
+
+	uid, err := podSecurityRunAsUser(cr, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	runAsNonRoot := true
+	securityContext.RunAsUser = &uid
+	securityContext.RunAsNonRoot = &runAsNonRoot
+
+	return securityContext, nil
+}
+
+// podSecurityRunAsUser picks the UID the registry pod should run as:
+// cr.Spec.Security.RunAsUser if the administrator pinned one (mirroring
+// the workload-user-id override OLM adopted for the same reason - some
+// storage backends need a stable UID across upgrades), otherwise the
+// first UID in the namespace's allocated openshift.io/sa.scc.uid-range.
+func podSecurityRunAsUser(cr *v1alpha1.OpenShiftDockerRegistry, ns *projectapi.Project) (int64, error) {
+	if cr.Spec.Security.RunAsUser != 0 {
+		return cr.Spec.Security.RunAsUser, nil
+	}
+
+	uidrange, ok := ns.Annotations[uidRangeAnnotation]
+	if !ok {
+		return 0, fmt.Errorf("namespace %q doesn't have annotation %s", ns.Name, uidRangeAnnotation)
+	}
+
+	idx := strings.Index(uidrange, "/")
+	if idx == -1 {
+		return 0, fmt.Errorf("annotation %s in namespace %q doesn't contain '/'", uidRangeAnnotation, ns.Name)
+	}
+
+	uid, err := strconv.ParseInt(uidrange[:idx], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse annotation %s in namespace %q: %s", uidRangeAnnotation, ns.Name, err)
+	}
+
+	return uid, nil
+}
+
+// generateContainerSecurityContext returns the registry container's
+// SecurityContext when cr.Spec.Security.Restricted is set, locking it
+// down to the "restricted" SCC's requirements; nil otherwise, so
+// existing deployments aren't affected on upgrade.
+func generateContainerSecurityContext(cr *v1alpha1.OpenShiftDockerRegistry) *corev1.SecurityContext {
+	if cr.Spec.Security == nil || !cr.Spec.Security.Restricted {
+		return nil
+	}
+
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// defaultTokenExpirationSeconds is the kubelet's refresh interval for a
+// projected service account token used as a web-identity credential;
+// 3600s matches what AWS STS/AssumeRoleWithWebIdentity and the
+// equivalent Azure workload-identity exchange both expect.
+const defaultTokenExpirationSeconds = int64(3600)
+
+// projectedServiceAccountTokenVolume builds a projected service account
+// token volume (and its mount) for cloud-provider workload-identity
+// federation: the kubelet refreshes the token for the given audience
+// roughly every hour and the cloud SDK inside the container reads it
+// straight off disk instead of a static credential. If path is empty, a
+// default under /var/run/secrets/<volumeName> is used so it doesn't
+// collide with the Kubernetes API token Kubernetes itself mounts.
+func projectedServiceAccountTokenVolume(volumeName, path, audience string) (corev1.Volume, corev1.VolumeMount, string) {
+	if path == "" {
+		path = fmt.Sprintf("/var/run/secrets/%s/serviceaccount/token", volumeName)
+	}
+
+	expiration := defaultTokenExpirationSeconds
+	vol := corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          audience,
+							ExpirationSeconds: &expiration,
+							Path:              filepath.Base(path),
+						},
+					},
+				},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: volumeName, MountPath: filepath.Dir(path), ReadOnly: true}
+
+	return vol, mount, path
 }
 
 func getSecretChecksum(p *parameters.Globals) (string, error) {
@@ -133,6 +253,195 @@ func getConfigMapChecksum(p *parameters.Globals) (string, error) {
 	return checksum(o)
 }
 
+// getNamedSecretChecksum is getSecretChecksum generalized to an
+// arbitrary Secret name, for callers - like the proxy credentials below -
+// that need to checksum a Secret other than
+// image-registry-private-configuration.
+func getNamedSecretChecksum(p *parameters.Globals, name string) (string, error) {
+	o := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.Deployment.Namespace,
+		},
+	}
+
+	err := sdk.Get(o)
+	if err != nil {
+		return "", err
+	}
+
+	return checksum(o)
+}
+
+// proxyMountName turns an upstream's remote URL into a filesystem-safe
+// name for its per-upstream credentials mount, e.g.
+// https://registry-1.docker.io becomes registry-1-docker-io.
+func proxyMountName(remoteURL string) string {
+	name := remoteURL
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(name)
+}
+
+// generateProxyConfig wires cr.Spec.Proxy.Upstreams into the pod. A
+// single upstream maps directly onto distribution's native
+// REGISTRY_PROXY_* env vars, with credentials pulled from the
+// referenced Secret via ValueFrom so they never appear inlined in the
+// Deployment. distribution itself only understands one upstream, so
+// multiple entries instead render a config.yml that lists every mirror
+// keyed by hostname - each with its own credential file paths rather
+// than inlined values - and mount it over
+// /etc/docker/registry/config.yml, replacing the env-var-only mode
+// entirely.
+func generateProxyConfig(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount, string, error) {
+	upstreams := cr.Spec.Proxy.Upstreams
+	if len(upstreams) == 0 {
+		return nil, nil, nil, "", nil
+	}
+
+	if len(upstreams) == 1 {
+		u := upstreams[0]
+		env := []corev1.EnvVar{
+			{Name: "REGISTRY_PROXY_REMOTEURL", Value: u.RemoteURL},
+		}
+
+		if u.CredentialsSecretRef == "" {
+			return env, nil, nil, "", nil
+		}
+
+		env = append(env,
+			corev1.EnvVar{
+				Name: "REGISTRY_PROXY_USERNAME",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: u.CredentialsSecretRef},
+						Key:                  "username",
+					},
+				},
+			},
+			corev1.EnvVar{
+				Name: "REGISTRY_PROXY_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: u.CredentialsSecretRef},
+						Key:                  "password",
+					},
+				},
+			},
+		)
+
+		credChecksum, err := getNamedSecretChecksum(p, u.CredentialsSecretRef)
+		if err != nil {
+			return nil, nil, nil, "", fmt.Errorf("unable to checksum proxy credentials secret %s: %s", u.CredentialsSecretRef, err)
+		}
+
+		return env, nil, nil, credChecksum, nil
+	}
+
+	var (
+		volumes         []corev1.Volume
+		mounts          []corev1.VolumeMount
+		config          strings.Builder
+		checksumSecrets []string
+	)
+
+	config.WriteString("proxy:\n  mirrors:\n")
+
+	for _, u := range upstreams {
+		hostname := proxyMountName(u.RemoteURL)
+		fmt.Fprintf(&config, "    %s:\n      remoteurl: %s\n", hostname, u.RemoteURL)
+
+		if u.CredentialsSecretRef == "" {
+			continue
+		}
+
+		volName := "proxy-credentials-" + hostname
+		mountPath := "/etc/docker/registry/proxy-secrets/" + hostname
+		volumes = append(volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: u.CredentialsSecretRef},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: volName, MountPath: mountPath, ReadOnly: true})
+		fmt.Fprintf(&config, "      usernamefile: %s/username\n      passwordfile: %s/password\n", mountPath, mountPath)
+
+		credChecksum, err := getNamedSecretChecksum(p, u.CredentialsSecretRef)
+		if err != nil {
+			return nil, nil, nil, "", fmt.Errorf("unable to checksum proxy credentials secret %s: %s", u.CredentialsSecretRef, err)
+		}
+		checksumSecrets = append(checksumSecrets, credChecksum)
+	}
+
+	configMap, err := ensureProxyConfigMap(p, config.String())
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	volumes = append(volumes, corev1.Volume{
+		Name: "registry-proxy-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: proxyConfigMapName}},
+		},
+	})
+	mounts = append(mounts, corev1.VolumeMount{Name: "registry-proxy-config", MountPath: "/etc/docker/registry/config.yml", SubPath: "config.yml"})
+
+	cmChecksum, err := checksum(configMap)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("unable to checksum proxy config map %s: %s", proxyConfigMapName, err)
+	}
+	checksumSecrets = append(checksumSecrets, cmChecksum)
+
+	combinedChecksum, err := checksum(checksumSecrets)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("unable to checksum proxy configuration: %s", err)
+	}
+
+	return nil, volumes, mounts, combinedChecksum, nil
+}
+
+// ensureProxyConfigMap creates or updates the proxy config.yml ConfigMap
+// to match data, returning the object as persisted so callers can
+// checksum exactly what's mounted.
+func ensureProxyConfigMap(p *parameters.Globals, data string) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      proxyConfigMapName,
+			Namespace: p.Deployment.Namespace,
+		},
+	}
+
+	err := sdk.Get(cm)
+	switch {
+	case err == nil:
+		if cm.Data["config.yml"] == data {
+			return cm, nil
+		}
+		cm.Data = map[string]string{"config.yml": data}
+		if err := sdk.Update(cm); err != nil {
+			return nil, fmt.Errorf("unable to update proxy config map %s: %s", proxyConfigMapName, err)
+		}
+		return cm, nil
+	case errors.IsNotFound(err):
+		cm.Data = map[string]string{"config.yml": data}
+		if err := sdk.Create(cm); err != nil {
+			return nil, fmt.Errorf("unable to create proxy config map %s: %s", proxyConfigMapName, err)
+		}
+		return cm, nil
+	default:
+		return nil, fmt.Errorf("unable to get proxy config map %s: %s", proxyConfigMapName, err)
+	}
+}
+
 func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals) (corev1.PodTemplateSpec, map[string]string, error) {
 	storageType := ""
 
@@ -192,18 +501,32 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 					},
 				},
 			},
-			corev1.EnvVar{
-				Name: "REGISTRY_STORAGE_AZURE_ACCOUNTKEY",
-				ValueFrom: &corev1.EnvVarSource{
-					SecretKeyRef: &corev1.SecretKeySelector{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "image-registry-private-configuration",
+		)
+
+		if cr.Spec.Storage.Azure.ClientID != "" && cr.Spec.Storage.Azure.TenantID != "" {
+			vol, mount, tokenFile := projectedServiceAccountTokenVolume("azure-identity-token", cr.Spec.Storage.Azure.TokenPath, "api://AzureADTokenExchange")
+			volumes = append(volumes, vol)
+			mounts = append(mounts, mount)
+			env = append(env,
+				corev1.EnvVar{Name: "AZURE_CLIENT_ID", Value: cr.Spec.Storage.Azure.ClientID},
+				corev1.EnvVar{Name: "AZURE_TENANT_ID", Value: cr.Spec.Storage.Azure.TenantID},
+				corev1.EnvVar{Name: "AZURE_FEDERATED_TOKEN_FILE", Value: tokenFile},
+			)
+		} else {
+			env = append(env,
+				corev1.EnvVar{
+					Name: "REGISTRY_STORAGE_AZURE_ACCOUNTKEY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "image-registry-private-configuration",
+							},
+							Key: "REGISTRY_STORAGE_AZURE_ACCOUNTKEY",
 						},
-						Key: "REGISTRY_STORAGE_AZURE_ACCOUNTKEY",
 					},
 				},
-			},
-		)
+			)
+		}
 		storageConfigured += 1
 	}
 
@@ -213,6 +536,12 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 			corev1.EnvVar{Name: "REGISTRY_STORAGE", Value: storageType},
 			corev1.EnvVar{Name: "REGISTRY_STORAGE_GCS_BUCKET", Value: cr.Spec.Storage.GCS.Bucket},
 		)
+		// When WorkloadIdentity is set, the Google service account it
+		// names is bound to the registry's ServiceAccount (see
+		// GenerateServiceAccount's iam.gke.io/gcp-service-account
+		// annotation) and GKE's metadata server transparently exchanges
+		// the pod's own Kubernetes token for Google credentials - unlike
+		// AWS/Azure, no extra volume or env var is needed here.
 		storageConfigured += 1
 	}
 
@@ -224,29 +553,43 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 			corev1.EnvVar{Name: "REGISTRY_STORAGE_S3_REGION", Value: cr.Spec.Storage.S3.Region},
 			corev1.EnvVar{Name: "REGISTRY_STORAGE_S3_REGIONENDPOINT", Value: cr.Spec.Storage.S3.RegionEndpoint},
 			corev1.EnvVar{Name: "REGISTRY_STORAGE_S3_ENCRYPT", Value: fmt.Sprintf("%v", cr.Spec.Storage.S3.Encrypt)},
-			corev1.EnvVar{
-				Name: "REGISTRY_STORAGE_S3_ACCESSKEY",
-				ValueFrom: &corev1.EnvVarSource{
-					SecretKeyRef: &corev1.SecretKeySelector{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "image-registry-private-configuration",
+		)
+
+		if cr.Spec.Storage.S3.RoleARN != "" {
+			vol, mount, tokenFile := projectedServiceAccountTokenVolume("aws-iam-token", cr.Spec.Storage.S3.TokenPath, "sts.amazonaws.com")
+			volumes = append(volumes, vol)
+			mounts = append(mounts, mount)
+			env = append(env,
+				corev1.EnvVar{Name: "AWS_ROLE_ARN", Value: cr.Spec.Storage.S3.RoleARN},
+				corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE", Value: tokenFile},
+				corev1.EnvVar{Name: "AWS_REGION", Value: cr.Spec.Storage.S3.Region},
+			)
+		} else {
+			env = append(env,
+				corev1.EnvVar{
+					Name: "REGISTRY_STORAGE_S3_ACCESSKEY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "image-registry-private-configuration",
+							},
+							Key: "REGISTRY_STORAGE_S3_ACCESSKEY",
 						},
-						Key: "REGISTRY_STORAGE_S3_ACCESSKEY",
 					},
 				},
-			},
-			corev1.EnvVar{
-				Name: "REGISTRY_STORAGE_S3_SECRETKEY",
-				ValueFrom: &corev1.EnvVarSource{
-					SecretKeyRef: &corev1.SecretKeySelector{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "image-registry-private-configuration",
+				corev1.EnvVar{
+					Name: "REGISTRY_STORAGE_S3_SECRETKEY",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: "image-registry-private-configuration",
+							},
+							Key: "REGISTRY_STORAGE_S3_SECRETKEY",
 						},
-						Key: "REGISTRY_STORAGE_S3_SECRETKEY",
 					},
 				},
-			},
-		)
+			)
+		}
 		storageConfigured += 1
 	}
 
@@ -320,6 +663,26 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 	if err != nil {
 		return corev1.PodTemplateSpec{}, nil, fmt.Errorf("generate security context for deployment config: %s", err)
 	}
+	containerSecurityContext := generateContainerSecurityContext(cr)
+
+	if cr.Spec.Security != nil && cr.Spec.Security.Restricted {
+		// A read-only root filesystem breaks distribution's default use
+		// of the storage root for temp files, so give it back a writable
+		// scratch directory without reopening the rest of the root FS.
+		volumes = append(volumes, corev1.Volume{
+			Name:         "registry-tmp",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "registry-tmp", MountPath: "/var/lib/registry"})
+	}
+
+	proxyEnv, proxyVolumes, proxyMounts, proxyChecksum, err := generateProxyConfig(cr, p)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, nil, fmt.Errorf("generate proxy configuration: %s", err)
+	}
+	env = append(env, proxyEnv...)
+	volumes = append(volumes, proxyVolumes...)
+	mounts = append(mounts, proxyMounts...)
 
 	//TLS
 	if *cr.Spec.TLS {
@@ -366,13 +729,18 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 		return corev1.PodTemplateSpec{}, nil, err
 	}
 
+	podAnnotations := map[string]string{
+		parameters.SecretChecksumOperatorAnnotation:    secretChecksum,
+		parameters.ConfigMapChecksumOperatorAnnotation: configmapChecksum,
+	}
+	if proxyChecksum != "" {
+		podAnnotations[parameters.ProxyChecksumOperatorAnnotation] = proxyChecksum
+	}
+
 	spec := corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels: p.Deployment.Labels,
-			Annotations: map[string]string{
-				parameters.SecretChecksumOperatorAnnotation:    secretChecksum,
-				parameters.ConfigMapChecksumOperatorAnnotation: configmapChecksum,
-			},
+			Labels:      p.Deployment.Labels,
+			Annotations: podAnnotations,
 		},
 		Spec: corev1.PodSpec{
 			NodeSelector: cr.Spec.NodeSelector,
@@ -386,10 +754,11 @@ func PodTemplateSpec(cr *v1alpha1.OpenShiftDockerRegistry, p *parameters.Globals
 							Protocol:      "TCP",
 						},
 					},
-					Env:            env,
-					VolumeMounts:   mounts,
-					LivenessProbe:  generateLivenessProbeConfig(cr, p),
-					ReadinessProbe: generateReadinessProbeConfig(cr, p),
+					Env:             env,
+					VolumeMounts:    mounts,
+					LivenessProbe:   generateLivenessProbeConfig(cr, p),
+					ReadinessProbe:  generateReadinessProbeConfig(cr, p),
+					SecurityContext: containerSecurityContext,
 					Resources: corev1.ResourceRequirements{
 						Requests: corev1.ResourceList{
 							corev1.ResourceCPU:    resource.MustParse("100m"),
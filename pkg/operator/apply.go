@@ -9,6 +9,7 @@ import (
 
 	appsapi "github.com/openshift/api/apps/v1"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	kmeta "k8s.io/apimachinery/pkg/api/meta"
@@ -137,6 +138,13 @@ func ApplyService(expect *corev1.Service, modified *bool) error {
 		current.Spec.Type = expect.Spec.Type
 		current.Spec.Ports = expect.Spec.Ports
 
+		// IPFamilyPolicy reflects what the CR is asking for, so it always
+		// follows expect. IPFamilies and ClusterIP(s) are allocated by the
+		// API server on create and are immutable afterwards; current's
+		// values are left as-is so an update never strips the IPv6
+		// assignment out from under a dual-stack Service.
+		current.Spec.IPFamilyPolicy = expect.Spec.IPFamilyPolicy
+
 		err = sdk.Update(current)
 		*modified = err == nil
 		return err
@@ -183,3 +191,62 @@ func ApplyDeploymentConfig(expect *appsapi.DeploymentConfig, modified *bool) err
 		return err
 	})
 }
+
+// ApplyDeployment is ApplyDeploymentConfig's counterpart for the apps/v1
+// Deployment kind GenerateDeployment produces when cr.Spec.ManagementStrategy
+// selects it.
+func ApplyDeployment(expect *appsv1.Deployment, modified *bool) error {
+	dgst, err := checksum(expect)
+	if err != nil {
+		return fmt.Errorf("unable to generate CR checksum: %s", err)
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		current := &appsv1.Deployment{
+			TypeMeta:   expect.TypeMeta,
+			ObjectMeta: expect.ObjectMeta,
+		}
+
+		err := sdk.Get(current)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to get deployment %s: %s", expect.GetName(), err)
+			}
+			err = sdk.Create(expect)
+			*modified = err == nil
+			return err
+		}
+
+		curdgst, ok := current.ObjectMeta.Annotations[checksumOperatorAnnotation]
+		if ok && dgst == curdgst {
+			return nil
+		}
+
+		if expect.ObjectMeta.Annotations == nil {
+			expect.ObjectMeta.Annotations = map[string]string{}
+		}
+		expect.ObjectMeta.Annotations[checksumOperatorAnnotation] = dgst
+
+		mergeObjectMeta(&current.ObjectMeta, &expect.ObjectMeta)
+		current.Spec = expect.Spec
+
+		err = sdk.Update(current)
+		*modified = err == nil
+		return err
+	})
+}
+
+// ApplyWorkload dispatches to ApplyDeploymentConfig or ApplyDeployment
+// depending on which concrete kind expect wraps, so callers that only
+// hold a registryWorkload - selected by cr.Spec.ManagementStrategy -
+// don't need their own type switch.
+func ApplyWorkload(expect registryWorkload, modified *bool) error {
+	switch w := expect.(type) {
+	case registryDeploymentConfig:
+		return ApplyDeploymentConfig(w.DeploymentConfig, modified)
+	case registryDeployment:
+		return ApplyDeployment(w.Deployment, modified)
+	default:
+		return fmt.Errorf("unsupported registry workload kind %T", expect)
+	}
+}
@@ -0,0 +1,128 @@
+package operator
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	kappsapi "k8s.io/api/apps/v1"
+	coreapi "k8s.io/api/core/v1"
+	rbacapi "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appsapi "github.com/openshift/api/apps/v1"
+	operatorapi "github.com/openshift/api/operator/v1alpha1"
+	routeapi "github.com/openshift/api/route/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+)
+
+// ImageRegistryController replaces the operator-sdk v0 Handle(ctx, event)
+// type switch with a controller-runtime Reconciler: reconcile is always
+// keyed off the owning ImageRegistry, so every event - whatever kind
+// triggered it - funnels through the same idempotent
+// CreateOrUpdateResources/RemoveResources path instead of the old
+// recursive reDeployByEvent chain.
+type ImageRegistryController struct {
+	Handler *Handler
+	Client  client.Client
+}
+
+var _ reconcile.Reconciler = &ImageRegistryController{}
+
+func (c *ImageRegistryController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	cr := &regopapi.ImageRegistry{}
+	if err := c.Client.Get(ctx, req.NamespacedName, cr); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if cr.ObjectMeta.DeletionTimestamp != nil {
+		if err := c.Handler.RemoveResources(cr); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+
+	var modified bool
+	switch cr.Spec.ManagementState {
+	case operatorapi.Removed:
+		if err := c.Handler.RemoveResources(cr); err != nil {
+			return reconcile.Result{}, err
+		}
+	case operatorapi.Managed:
+		if err := c.Handler.CreateOrUpdateResources(cr, &modified); err != nil {
+			logrus.Errorf("unable to sync %s/%s: %s", cr.Namespace, cr.Name, err)
+			return reconcile.Result{}, err
+		}
+	case operatorapi.Unmanaged:
+		// ignore
+	}
+
+	if modified {
+		cr.Status.ObservedGeneration = cr.Generation
+		if err := c.Client.Status().Update(ctx, cr); err != nil {
+			if !errors.IsConflict(err) {
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{Requeue: true}, nil
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// ownedKindPredicate drops update events that carry no change at all,
+// such as a periodic resync re-delivering an object with the same
+// generation and resourceVersion. Any real write passes, status-only
+// updates on objects we own included (e.g. a Deployment's rollout status
+// ticking over), since that's exactly what tells us a rollout finished.
+// Spec contents are not compared. Create, delete and generic events
+// always pass.
+var ownedKindPredicate = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return true },
+	DeleteFunc:  func(event.DeleteEvent) bool { return true },
+	GenericFunc: func(event.GenericEvent) bool { return true },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+			e.ObjectOld.GetResourceVersion() != e.ObjectNew.GetResourceVersion()
+	},
+}
+
+// SetupWithManager registers a single ImageRegistry controller that also
+// watches every kind the operator owns, each watch enqueuing the owning
+// ImageRegistry rather than handling the event itself.
+func (c *ImageRegistryController) SetupWithManager(mgr manager.Manager) error {
+	owner := &handler.EnqueueRequestForOwner{OwnerType: &regopapi.ImageRegistry{}, IsController: true}
+
+	ownedKinds := []runtime.Object{
+		&rbacapi.ClusterRole{},
+		&rbacapi.ClusterRoleBinding{},
+		&coreapi.ServiceAccount{},
+		&coreapi.ConfigMap{},
+		&coreapi.Secret{},
+		&coreapi.Service{},
+		&routeapi.Route{},
+		&kappsapi.Deployment{},
+		&appsapi.DeploymentConfig{},
+	}
+
+	bld := ctrl.NewControllerManagedBy(mgr).For(&regopapi.ImageRegistry{})
+	for _, kind := range ownedKinds {
+		bld = bld.Watches(&source.Kind{Type: kind}, owner, builder.WithPredicates(ownedKindPredicate))
+	}
+
+	return bld.Complete(c)
+}
@@ -1,29 +1,32 @@
 package operator
 
 import (
-	"context"
 	"fmt"
-	"os"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
 
-	kappsapi "k8s.io/api/apps/v1"
 	coreapi "k8s.io/api/core/v1"
-	rbacapi "k8s.io/api/rbac/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	kmeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 
-	appsapi "github.com/openshift/api/apps/v1"
+	configapi "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
 	operatorapi "github.com/openshift/api/operator/v1alpha1"
 	routeapi "github.com/openshift/api/route/v1"
 
 	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
-	osapi "github.com/openshift/cluster-version-operator/pkg/apis/operatorstatus.openshift.io/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 
+	"github.com/openshift/cluster-image-registry-operator/pkg/certrotation"
 	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
 	"github.com/openshift/cluster-image-registry-operator/pkg/metautil"
 	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
@@ -31,7 +34,7 @@ import (
 	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
 )
 
-func NewHandler(namespace string) (sdk.Handler, error) {
+func NewHandler(namespace string) (*Handler, error) {
 	operatorNamespace, err := k8sutil.GetWatchNamespace()
 	if err != nil {
 		logrus.Fatalf("Failed to get watch namespace: %v", err)
@@ -57,12 +60,10 @@ func NewHandler(namespace string) (sdk.Handler, error) {
 	p.ImageConfig.Name = "cluster"
 
 	h := &Handler{
-		params:             p,
-		generateDeployment: resource.Deployment,
-		clusterStatus:      clusteroperator.NewStatusHandler(operatorName, operatorNamespace),
+		params:        p,
+		clusterStatus: clusteroperator.NewStatusHandler(operatorName, operatorNamespace),
 	}
 
-	return h, nil
 	_, err = h.Bootstrap()
 	if err != nil {
 		return nil, err
@@ -77,114 +78,74 @@ func NewHandler(namespace string) (sdk.Handler, error) {
 }
 
 type Handler struct {
-	params             parameters.Globals
-	generateDeployment resource.Generator
-	clusterStatus      *clusteroperator.StatusHandler
+	params        parameters.Globals
+	clusterStatus *clusteroperator.StatusHandler
 }
 
-func isDeploymentStatusAvailable(o runtime.Object) bool {
-	switch deploy := o.(type) {
-	case *appsapi.DeploymentConfig:
-		return deploy.Status.AvailableReplicas > 0
-	case *kappsapi.Deployment:
-		return deploy.Status.AvailableReplicas > 0
-	}
-	return false
-}
+// recordRelatedObjects fills in cr.Status.RelatedObjects with every
+// object the operator just applied, plus the operator's own namespace, so
+// that `oc adm must-gather` and similar tooling know what to collect. It
+// also hands the same list to clusterStatus, which publishes it onto the
+// ClusterOperator so it survives even when the ImageRegistry CR itself is
+// not inspected directly.
+func (h *Handler) recordRelatedObjects(cr *regopapi.ImageRegistry, templates []resource.Template, modified *bool) {
+	related := make([]configapi.ObjectReference, 0, len(templates)+1)
+	related = append(related, configapi.ObjectReference{
+		Resource: "namespaces",
+		Name:     h.params.Deployment.Namespace,
+	})
 
-func isDeploymentStatusComplete(o runtime.Object) bool {
-	switch deploy := o.(type) {
-	case *appsapi.DeploymentConfig:
-		return deploy.Status.UpdatedReplicas == deploy.Spec.Replicas &&
-			deploy.Status.Replicas == deploy.Spec.Replicas &&
-			deploy.Status.AvailableReplicas == deploy.Spec.Replicas &&
-			deploy.Status.ObservedGeneration >= deploy.Generation
-	case *kappsapi.Deployment:
-		replicas := int32(1)
-		if deploy.Spec.Replicas != nil {
-			replicas = *(deploy.Spec.Replicas)
+	for _, tpl := range templates {
+		accessor, err := kmeta.Accessor(tpl.Object)
+		if err != nil {
+			logrus.Errorf("unable to get meta accessor for %s: %s", tpl.Name(), err)
+			continue
 		}
-		return deploy.Status.UpdatedReplicas == replicas &&
-			deploy.Status.Replicas == replicas &&
-			deploy.Status.AvailableReplicas == replicas &&
-			deploy.Status.ObservedGeneration >= deploy.Generation
-	}
-	return false
-}
-
-func (h *Handler) syncDeploymentStatus(cr *regopapi.ImageRegistry, o runtime.Object, statusChanged *bool) {
-	operatorAvailable := osapi.ConditionFalse
-	operatorAvailableMsg := ""
-
-	if isDeploymentStatusAvailable(o) {
-		operatorAvailable = osapi.ConditionTrue
-		operatorAvailableMsg = "deployment has minimum availability"
-	}
-
-	errOp := h.clusterStatus.Update(osapi.OperatorAvailable, operatorAvailable, operatorAvailableMsg)
-	if errOp != nil {
-		logrus.Errorf("unable to update cluster status to %s=%s: %s", osapi.OperatorAvailable, osapi.ConditionTrue, errOp)
+		gvk := tpl.Object.GetObjectKind().GroupVersionKind()
+		related = append(related, configapi.ObjectReference{
+			Group:     gvk.Group,
+			Resource:  strings.ToLower(gvk.Kind) + "s",
+			Namespace: accessor.GetNamespace(),
+			Name:      accessor.GetName(),
+		})
 	}
 
-	operatorProgressing := osapi.ConditionTrue
-	operatorProgressingMsg := "deployment is progressing"
-
-	if isDeploymentStatusComplete(o) {
-		operatorProgressing = osapi.ConditionFalse
-		operatorProgressingMsg = "deployment successfully progressed"
-	}
-
-	errOp = h.clusterStatus.Update(osapi.OperatorProgressing, operatorProgressing, operatorProgressingMsg)
-	if errOp != nil {
-		logrus.Errorf("unable to update cluster status to %s=%s: %s", osapi.OperatorProgressing, operatorProgressing, errOp)
+	if !reflect.DeepEqual(cr.Status.RelatedObjects, related) {
+		cr.Status.RelatedObjects = related
+		*modified = true
 	}
 
-	syncSuccessful := operatorapi.ConditionFalse
-
-	if operatorProgressing == osapi.ConditionFalse {
-		syncSuccessful = operatorapi.ConditionTrue
+	if err := h.clusterStatus.SetRelatedObjects(related); err != nil {
+		logrus.Errorf("unable to publish related objects for %s: %s", metautil.TypeAndName(cr), err)
 	}
-
-	conditionSyncDeployment(cr, syncSuccessful, operatorProgressingMsg, statusChanged)
 }
 
-func updateCondition(cr *regopapi.ImageRegistry, condition *operatorapi.OperatorCondition) bool {
-	modified := false
-	found := false
-	conditions := []operatorapi.OperatorCondition{}
-
-	for _, c := range cr.Status.Conditions {
-		if condition.Type != c.Type {
-			conditions = append(conditions, c)
-			continue
-		}
-		if condition.Status != c.Status {
-			modified = true
-		}
-		conditions = append(conditions, *condition)
-		found = true
+// updateCondition sets condition on cr.Status.Conditions via
+// v1helpers.SetStatusCondition, which owns bumping LastTransitionTime
+// only when Status actually flips. It reports whether anything changed
+// so callers can fold that into the *bool they thread through to decide
+// whether a status write is needed at all.
+func updateCondition(cr *regopapi.ImageRegistry, condition operatorv1.OperatorCondition) bool {
+	existing := v1helpers.FindOperatorCondition(cr.Status.Conditions, condition.Type)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+		return false
 	}
 
-	if !found {
-		conditions = append(conditions, *condition)
-		modified = true
-	}
-
-	cr.Status.Conditions = conditions
-	return modified
+	v1helpers.SetStatusCondition(&cr.Status.Conditions, condition)
+	return true
 }
 
-func conditionResourceApply(cr *regopapi.ImageRegistry, status operatorapi.ConditionStatus, m string, modified *bool) {
-	if status == operatorapi.ConditionFalse {
-		logrus.Errorf("condition failed on %s: %s", metautil.TypeAndName(cr), m)
-	}
-
-	changed := updateCondition(cr, &operatorapi.OperatorCondition{
-		Type:               operatorapi.OperatorStatusTypeAvailable,
-		Status:             status,
-		LastTransitionTime: metav1.Now(),
-		Reason:             "ResourceApplied",
-		Message:            m,
+// conditionUpgradeable reports whether the operator currently has
+// anything that would block a cluster upgrade. Nothing about this
+// operator's resources depends on the cluster version, so it is always
+// upgradeable; this exists mainly so Upgradeable shows up in
+// Status.Conditions at all, the way cluster-version-operator expects.
+func conditionUpgradeable(cr *regopapi.ImageRegistry, modified *bool) {
+	changed := updateCondition(cr, operatorv1.OperatorCondition{
+		Type:    operatorv1.OperatorStatusTypeUpgradeable,
+		Status:  operatorv1.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: "the registry operator does not block upgrades",
 	})
 
 	if changed {
@@ -192,19 +153,34 @@ func conditionResourceApply(cr *regopapi.ImageRegistry, status operatorapi.Condi
 	}
 }
 
-func conditionSyncDeployment(cr *regopapi.ImageRegistry, syncSuccessful operatorapi.ConditionStatus, m string, modified *bool) {
-	reason := "DeploymentProgressed"
+// degradedReason classifies an error returned while applying a single
+// template into a stable Reason code, so that cluster-version-operator
+// consumers can tell a transient failure from a terminal one without
+// parsing the message text.
+func degradedReason(err error) string {
+	switch {
+	case errors.IsNotFound(err):
+		return "ResourceNotFound"
+	case errors.IsBadRequest(err), errors.IsInvalid(err):
+		return "InvalidConfiguration"
+	default:
+		return "ResourceApplyFailed"
+	}
+}
 
-	if syncSuccessful == operatorapi.ConditionFalse {
-		reason = "DeploymentInProgress"
+// conditionDegraded sets the Degraded condition, mirroring the pattern
+// other OpenShift operators use to distinguish "not yet available" from
+// "actively broken".
+func conditionDegraded(cr *regopapi.ImageRegistry, status operatorapi.ConditionStatus, reason, m string, modified *bool) {
+	if status == operatorapi.ConditionTrue {
+		logrus.Errorf("degraded condition on %s (%s): %s", metautil.TypeAndName(cr), reason, m)
 	}
 
-	changed := updateCondition(cr, &operatorapi.OperatorCondition{
-		Type:               operatorapi.OperatorStatusTypeSyncSuccessful,
-		Status:             syncSuccessful,
-		LastTransitionTime: metav1.Now(),
-		Reason:             reason,
-		Message:            m,
+	changed := updateCondition(cr, operatorv1.OperatorCondition{
+		Type:    operatorv1.OperatorStatusTypeDegraded,
+		Status:  operatorv1.ConditionStatus(status),
+		Reason:  reason,
+		Message: m,
 	})
 
 	if changed {
@@ -212,338 +188,152 @@ func conditionSyncDeployment(cr *regopapi.ImageRegistry, syncSuccessful operator
 	}
 }
 
-func (h *Handler) reCreateByEvent(event sdk.Event, gen resource.Generator) (*regopapi.ImageRegistry, bool, error) {
-	o := event.Object.(metav1.Object)
-
-	cr, err := h.getImageRegistryForResource(o)
-	if err != nil {
-		return nil, false, err
-	}
-
-	if cr == nil || !metav1.IsControlledBy(o, cr) {
-		return cr, false, nil
-	}
-
-	statusChanged := false
-
-	tmpl, err := gen(cr, &h.params)
-	if err != nil {
-		conditionResourceApply(cr, operatorapi.ConditionFalse,
-			fmt.Sprintf("unable to make template for %T %s/%s: %s", o, o.GetNamespace(), o.GetName(), err),
-			&statusChanged,
-		)
-		return cr, statusChanged, nil
-	}
-
-	err = resource.ApplyTemplate(tmpl, false, &statusChanged)
-	if err != nil {
-		conditionResourceApply(cr, operatorapi.ConditionFalse,
-			fmt.Sprintf("unable to apply template %s: %s", tmpl.Name(), err),
-			&statusChanged,
-		)
-		return cr, statusChanged, nil
-	}
-
-	if statusChanged {
-		logrus.Debugf("resource %s is recreated", tmpl.Name())
-		conditionResourceApply(cr, operatorapi.ConditionTrue, "all resources applied", &statusChanged)
+// generateWorkload picks DeploymentConfig or Deployment per
+// o.Spec.ManagementStrategy - DeploymentConfig is the zero-value default,
+// so CRs created before this field existed keep deploying the same kind
+// they always have.
+func generateWorkload(o *regopapi.ImageRegistry) resource.TemplateGenerator {
+	if o.Spec.ManagementStrategy == regopapi.ManagementStrategyDeployment {
+		return resource.Deployment
 	}
-
-	return cr, statusChanged, nil
+	return resource.DeploymentConfig
 }
 
-func (h *Handler) reDeployByEvent(event sdk.Event, gen resource.Generator) (*regopapi.ImageRegistry, bool, error) {
-	cr, statusChanged, err := h.reCreateByEvent(event, gen)
-	if err != nil {
-		return cr, statusChanged, err
-	}
-
-	if !statusChanged {
-		return cr, false, nil
+func (h *Handler) GenerateTemplates(o *regopapi.ImageRegistry, p *parameters.Globals) (ret []resource.Template, err error) {
+	generators := []resource.TemplateGenerator{
+		resource.ClusterRole,
+		resource.ClusterRoleBinding,
+		resource.ServiceAccount,
+		resource.ConfigMap,
+		resource.Secret,
+		resource.Service,
+		resource.ImageConfig,
 	}
 
-	tmpl, err := h.generateDeployment(cr, &h.params)
-	if err != nil {
-		conditionResourceApply(cr, operatorapi.ConditionFalse,
-			fmt.Sprintf("unable to make template for %T: %s", event.Object, err),
-			&statusChanged,
-		)
-		return cr, statusChanged, nil
+	routes := resource.GetRouteGenerators(o, p)
+	for i := range routes {
+		generators = append(generators, routes[i])
 	}
 
-	err = resource.ApplyTemplate(tmpl, true, &statusChanged)
-	if err != nil {
-		conditionResourceApply(cr, operatorapi.ConditionFalse,
-			fmt.Sprintf("unable to apply template %s: %s", tmpl.Name(), err),
-			&statusChanged,
-		)
-		return cr, statusChanged, nil
+	// A single proxy upstream is expressed purely as env vars on the
+	// workload (see injectProxyConfig), but distribution can't natively
+	// mirror more than one, so that case also needs ProxyConfigMap's
+	// rendered config.yml.
+	if len(o.Spec.Proxy.Upstreams) > 1 {
+		generators = append(generators, resource.ProxyConfigMap)
 	}
 
-	conditionResourceApply(cr, operatorapi.ConditionTrue, "all resources applied", &statusChanged)
-
-	return cr, statusChanged, nil
-}
-
-func (h *Handler) Handle(ctx context.Context, event sdk.Event) error {
-	return nil
-	logrus.Debugf("received event for %T (deleted=%t)", event.Object, event.Deleted)
-
-	var (
-		statusChanged bool
-		err           error
-		cr            *regopapi.ImageRegistry
-	)
-
-	switch o := event.Object.(type) {
-	case *rbacapi.ClusterRole:
-		cr, statusChanged, err = h.reCreateByEvent(event, resource.ClusterRole)
-		if err != nil {
-			return err
-		}
-
-	case *rbacapi.ClusterRoleBinding:
-		cr, statusChanged, err = h.reCreateByEvent(event, resource.ClusterRoleBinding)
-		if err != nil {
-			return err
-		}
-
-	case *coreapi.Service:
-		cr, statusChanged, err = h.reCreateByEvent(event, resource.Service)
-		if err != nil {
-			return err
-		}
-		if cr != nil {
-			svc := event.Object.(*coreapi.Service)
-			svcHostname := fmt.Sprintf("%s.%s.svc.cluster.local:%d", svc.Name, svc.Namespace, svc.Spec.Ports[0].Port)
-			if cr.Status.InternalRegistryHostname != svcHostname {
-				cr.Status.InternalRegistryHostname = svcHostname
-				statusChanged = true
-			}
-		}
-
-	case *coreapi.ServiceAccount:
-		cr, statusChanged, err = h.reDeployByEvent(event, resource.ServiceAccount)
-		if err != nil {
-			return err
-		}
-
-	case *coreapi.ConfigMap:
-		cr, statusChanged, err = h.reDeployByEvent(event, resource.ConfigMap)
-		if err != nil {
-			return err
-		}
-
-	case *coreapi.Secret:
-		cr, statusChanged, err = h.reDeployByEvent(event, resource.Secret)
-		if err != nil {
-			return err
-		}
+	generators = append(generators, generateWorkload(o))
 
-	case *routeapi.Route:
-		cr, err = h.getImageRegistryForResource(&o.ObjectMeta)
-		if err != nil {
-			return err
-		}
-
-		if cr == nil || !metav1.IsControlledBy(o, cr) {
-			return nil
-		}
-
-		routes := resource.GetRouteGenerators(cr, &h.params)
-
-		if gen, found := routes[o.ObjectMeta.Name]; found {
-			tmpl, err := gen(cr, &h.params)
-			if err != nil {
-				conditionResourceApply(cr, operatorapi.ConditionFalse,
-					fmt.Sprintf("unable to make template for %T %s/%s: %s", o, o.GetNamespace(), o.GetName(), err),
-					&statusChanged,
-				)
-				break
-			}
-
-			err = resource.ApplyTemplate(tmpl, false, &statusChanged)
-			if err != nil {
-				conditionResourceApply(cr, operatorapi.ConditionFalse,
-					fmt.Sprintf("unable to apply template %s: %s", tmpl.Name(), err),
-					&statusChanged,
-				)
-				break
-			}
-		}
+	ret = make([]resource.Template, len(generators))
 
-	case *kappsapi.Deployment:
-		cr, err = h.getImageRegistryForResource(o)
+	for i, gen := range generators {
+		ret[i], err = gen(o, p)
 		if err != nil {
-			return err
-		}
-
-		if cr == nil || !metav1.IsControlledBy(o, cr) {
-			return nil
-		}
-
-		if event.Deleted {
-			tmpl, err := resource.Deployment(cr, &h.params)
-			if err != nil {
-				return err
-			}
-
-			err = resource.ApplyTemplate(tmpl, false, &statusChanged)
-			if err != nil {
-				return err
-			}
-
-			logrus.Debugf("resource %s is recreated", tmpl.Name())
-			break
+			return
 		}
+	}
 
-		h.syncDeploymentStatus(cr, o, &statusChanged)
-
-	case *appsapi.DeploymentConfig:
-		cr, err = h.getImageRegistryForResource(&o.ObjectMeta)
-		if err != nil {
-			return err
-		}
+	return
+}
 
-		if cr == nil || !metav1.IsControlledBy(o, cr) {
-			return nil
+// isRouteAdmitted reports whether ingress carries an Admitted=True
+// condition.
+func isRouteAdmitted(ingress routeapi.RouteIngress) bool {
+	for _, c := range ingress.Conditions {
+		if c.Type == routeapi.RouteAdmitted {
+			return c.Status == coreapi.ConditionTrue
 		}
+	}
+	return false
+}
 
-		if event.Deleted {
-			tmpl, err := resource.DeploymentConfig(cr, &h.params)
-			if err != nil {
-				return err
-			}
-
-			err = resource.ApplyTemplate(tmpl, false, &statusChanged)
-			if err != nil {
-				return err
-			}
-
-			logrus.Debugf("resource %s is recreated", tmpl.Name())
-			break
+// syncRoutes reads back the Host each of cr's Routes was admitted with
+// and publishes the result as cr.Status.ExternalRegistryHostnames, then
+// republishes InternalRegistryHostname/ExternalRegistryHostnames onto the
+// cluster-scoped Image config object so the API server's image policy
+// plugin trusts pulls through any of these hostnames. That object is
+// written here directly rather than through the generic template loop in
+// GenerateTemplates, because only syncRoutes knows the current set of
+// hostnames once the Routes themselves have been admitted.
+func syncRoutes(cr *regopapi.ImageRegistry, p *parameters.Globals, modified *bool) error {
+	var hostnames []string
+
+	for name := range resource.GetRouteGenerators(cr, p) {
+		route := &routeapi.Route{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "route.openshift.io/v1", Kind: "Route"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: p.Deployment.Namespace},
 		}
 
-		h.syncDeploymentStatus(cr, o, &statusChanged)
-
-	case *regopapi.ImageRegistry:
-		cr = event.Object.(*regopapi.ImageRegistry)
-
-		if cr.ObjectMeta.DeletionTimestamp != nil {
-			cr, err = h.Bootstrap()
-			if err != nil {
-				return err
+		if err := sdk.Get(route); err != nil {
+			if errors.IsNotFound(err) {
+				continue
 			}
+			return fmt.Errorf("unable to get route %s: %s", name, err)
 		}
 
-		switch cr.Spec.ManagementState {
-		case operatorapi.Removed:
-			err = h.RemoveResources(cr)
-			if err != nil {
-				conditionResourceApply(o, operatorapi.ConditionFalse, fmt.Sprintf("unable to remove objects: %s", err), &statusChanged)
-			}
-		case operatorapi.Managed:
-			err = h.CreateOrUpdateResources(cr, &statusChanged)
-
-			if err != nil {
-				errOp := h.clusterStatus.Update(osapi.OperatorFailing, osapi.ConditionTrue, "unable to deploy registry")
-				if errOp != nil {
-					logrus.Errorf("unable to update cluster status to %s=%s: %s", osapi.OperatorFailing, osapi.ConditionTrue, errOp)
-				}
-				conditionResourceApply(o, operatorapi.ConditionFalse, err.Error(), &statusChanged)
-			} else {
-				errOp := h.clusterStatus.Update(osapi.OperatorFailing, osapi.ConditionFalse, "")
-				if errOp != nil {
-					logrus.Errorf("unable to update cluster status to %s=%s: %s", osapi.OperatorFailing, osapi.ConditionFalse, errOp)
-				}
-				conditionResourceApply(o, operatorapi.ConditionTrue, "all resources applied", &statusChanged)
+		for _, ingress := range route.Status.Ingress {
+			if isRouteAdmitted(ingress) {
+				hostnames = append(hostnames, ingress.Host)
 			}
-		case operatorapi.Unmanaged:
-			// ignore
 		}
 	}
 
-	if cr != nil && statusChanged {
-		logrus.Infof("%s changed", metautil.TypeAndName(cr))
+	sort.Strings(hostnames)
 
-		cr.Status.ObservedGeneration = cr.Generation
-
-		err = sdk.Update(cr)
-		if err != nil && !errors.IsConflict(err) {
-			logrus.Errorf("unable to update %s: %s", metautil.TypeAndName(cr), err)
-		}
+	if !reflect.DeepEqual([]string(cr.Status.ExternalRegistryHostnames), hostnames) {
+		cr.Status.ExternalRegistryHostnames = hostnames
+		*modified = true
 	}
 
-	return nil
-}
-
-func (h *Handler) getImageRegistryForResource(o metav1.Object) (*regopapi.ImageRegistry, error) {
-	ownerRef := metav1.GetControllerOf(o)
-
-	if ownerRef == nil || ownerRef.Kind != "ImageRegistry" || ownerRef.APIVersion != regopapi.SchemeGroupVersion.String() {
-		return nil, nil
+	imageConfig := &configapi.Image{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "config.openshift.io/v1", Kind: "Image"},
+		ObjectMeta: metav1.ObjectMeta{Name: p.ImageConfig.Name},
 	}
-
-	namespace := o.GetNamespace()
-	if len(namespace) == 0 {
-		// FIXME
-		namespace = os.Getenv("WATCH_NAMESPACE")
+	if err := sdk.Get(imageConfig); err != nil {
+		return fmt.Errorf("unable to get image config %s: %s", p.ImageConfig.Name, err)
 	}
 
-	cr := &regopapi.ImageRegistry{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: ownerRef.APIVersion,
-			Kind:       ownerRef.Kind,
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      ownerRef.Name,
-			Namespace: namespace,
-		},
+	internalHostname := fmt.Sprintf("%s.%s.svc:5000", p.Service.Name, p.Deployment.Namespace)
+	if imageConfig.Status.InternalRegistryHostname == internalHostname && reflect.DeepEqual(imageConfig.Status.ExternalRegistryHostnames, hostnames) {
+		return nil
 	}
 
-	err := sdk.Get(cr)
-	if err != nil {
-		if !errors.IsNotFound(err) {
-			return nil, fmt.Errorf("failed to get %q custom resource: %s", ownerRef.Name, err)
-		}
-		return nil, nil
-	}
+	imageConfig.Status.InternalRegistryHostname = internalHostname
+	imageConfig.Status.ExternalRegistryHostnames = hostnames
 
-	if cr.Spec.ManagementState != operatorapi.Managed {
-		return nil, nil
+	if err := sdk.Update(imageConfig); err != nil {
+		return fmt.Errorf("unable to publish registry hostnames on image config %s: %s", p.ImageConfig.Name, err)
 	}
 
-	return cr, nil
+	return nil
 }
 
-func (h *Handler) GenerateTemplates(o *regopapi.ImageRegistry, p *parameters.Globals) (ret []resource.Template, err error) {
-	generators := []resource.Generator{
-		resource.ClusterRole,
-		resource.ClusterRoleBinding,
-		resource.ServiceAccount,
-		resource.ConfigMap,
-		resource.Secret,
-		resource.Service,
-		resource.ImageConfig,
-	}
-
-	routes := resource.GetRouteGenerators(o, p)
-	for i := range routes {
-		generators = append(generators, routes[i])
+// syncDisruptionBudget applies the PodDisruptionBudget GeneratePodDisruptionBudget
+// computes for o, or deletes a previously-created one once o.Spec.Replicas
+// drops back to a value it no longer wants a budget for. Unlike the
+// generators GenerateTemplates drives, this isn't routed through the YAML
+// template repo in pkg/resource: it's the one generator in
+// pkg/operator/generate.go that doesn't duplicate an already-wired
+// pkg/resource generator, so it's safe to apply directly here.
+func (h *Handler) syncDisruptionBudget(o *regopapi.ImageRegistry, modified *bool) error {
+	tmpl, want, err := GeneratePodDisruptionBudget(o, &h.params)
+	if err != nil {
+		return fmt.Errorf("unable to generate pod disruption budget: %s", err)
 	}
 
-	generators = append(generators, h.generateDeployment)
-
-	ret = make([]resource.Template, len(generators))
-
-	for i, gen := range generators {
-		ret[i], err = gen(o, p)
-		if err != nil {
-			return
+	if !want {
+		pdb := &policyv1.PodDisruptionBudget{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "policy/v1", Kind: "PodDisruptionBudget"},
+			ObjectMeta: metav1.ObjectMeta{Name: h.params.Deployment.Name, Namespace: h.params.Deployment.Namespace},
+		}
+		if err := sdk.Delete(pdb); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to prune stale pod disruption budget: %s", err)
 		}
+		return nil
 	}
 
-	return
+	return ApplyTemplate(tmpl, modified)
 }
 
 func (h *Handler) CreateOrUpdateResources(o *regopapi.ImageRegistry, modified *bool) error {
@@ -569,18 +359,55 @@ func (h *Handler) CreateOrUpdateResources(o *regopapi.ImageRegistry, modified *b
 		return fmt.Errorf("bad custom resource: %s", err)
 	}
 
+	// Probe runs on every reconcile rather than its own ticker: controller-
+	// runtime already resyncs Reconcile periodically independent of watch
+	// events, so this is enough to keep StorageAvailable current without a
+	// second, purpose-built polling loop. A failed probe only degrades that
+	// one condition - it must not block applying the rest of the objects
+	// below, which is why it's handled here instead of propagated up.
+	if err := driver.Probe(h.clusterStatus); err != nil {
+		logrus.Errorf("storage probe for %s failed: %s", metautil.TypeAndName(o), err)
+	}
+
+	if err := certrotation.Reconcile(o, &h.params, modified); err != nil {
+		return fmt.Errorf("unable to reconcile TLS certificates: %s", err)
+	}
+
 	templates, err := h.GenerateTemplates(o, &h.params)
 	if err != nil {
 		return fmt.Errorf("unable to generate templates: %s", err)
 	}
 
+	// Keep applying every independent template even if one of them fails,
+	// so that a single bad object (say, a Route with an invalid hostname)
+	// doesn't hide failures in unrelated objects behind it.
+	var applyErrs []error
+	firstReason := ""
 	for _, tpl := range templates {
-		err = resource.ApplyTemplate(tpl, false, modified)
-		if err != nil {
-			return fmt.Errorf("unable to apply objects: %s", err)
+		if err := resource.ApplyTemplate(tpl, false, modified); err != nil {
+			if firstReason == "" {
+				firstReason = degradedReason(err)
+			}
+			applyErrs = append(applyErrs, fmt.Errorf("%s (%s): %s", tpl.Name(), degradedReason(err), err))
 		}
 	}
 
+	if aggregate := utilerrors.NewAggregate(applyErrs); aggregate != nil {
+		conditionDegraded(o, operatorapi.ConditionTrue, firstReason, aggregate.Error(), modified)
+		return fmt.Errorf("unable to apply objects: %s", aggregate)
+	}
+	conditionDegraded(o, operatorapi.ConditionFalse, "AsExpected", "all resources applied", modified)
+	conditionUpgradeable(o, modified)
+	h.recordRelatedObjects(o, templates, modified)
+
+	if err := h.syncDisruptionBudget(o, modified); err != nil {
+		return fmt.Errorf("unable to sync pod disruption budget: %s", err)
+	}
+
+	if err := resource.PruneStaleWorkload(o, &h.params); err != nil {
+		return fmt.Errorf("unable to prune stale workload: %s", err)
+	}
+
 	err = syncRoutes(o, &h.params, modified)
 	if err != nil {
 		return fmt.Errorf("unable to sync routes: %s", err)
@@ -0,0 +1,71 @@
+package operator
+
+import (
+	"fmt"
+	"testing"
+
+	coreapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	routeapi "github.com/openshift/api/route/v1"
+)
+
+func TestDegradedReason(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"not found", errors.NewNotFound(gr, "image-registry"), "ResourceNotFound"},
+		{"bad request", errors.NewBadRequest("invalid hostname"), "InvalidConfiguration"},
+		{"invalid", errors.NewInvalid(gr.WithVersion("v1").GroupKind(), "image-registry", nil), "InvalidConfiguration"},
+		{"anything else", fmt.Errorf("connection refused"), "ResourceApplyFailed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := degradedReason(tt.err); got != tt.want {
+				t.Errorf("degradedReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRouteAdmitted(t *testing.T) {
+	tests := []struct {
+		name     string
+		ingress  routeapi.RouteIngress
+		admitted bool
+	}{
+		{
+			name: "admitted",
+			ingress: routeapi.RouteIngress{Conditions: []routeapi.RouteIngressCondition{
+				{Type: routeapi.RouteAdmitted, Status: coreapi.ConditionTrue},
+			}},
+			admitted: true,
+		},
+		{
+			name: "not admitted",
+			ingress: routeapi.RouteIngress{Conditions: []routeapi.RouteIngressCondition{
+				{Type: routeapi.RouteAdmitted, Status: coreapi.ConditionFalse},
+			}},
+			admitted: false,
+		},
+		{
+			name:     "no conditions yet",
+			ingress:  routeapi.RouteIngress{},
+			admitted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRouteAdmitted(tt.ingress); got != tt.admitted {
+				t.Errorf("isRouteAdmitted(%+v) = %v, want %v", tt.ingress, got, tt.admitted)
+			}
+		})
+	}
+}
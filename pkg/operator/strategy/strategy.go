@@ -0,0 +1,122 @@
+// Package strategy defines how a desired object gets merged onto the
+// object that is currently live on the cluster.
+package strategy
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Strategy merges a desired object onto the object that is currently on
+// the cluster, returning the object that should be sent to Update.
+type Strategy interface {
+	Apply(current, desired runtime.Object) (runtime.Object, error)
+}
+
+// Override replaces the live object outright, keeping only the fields
+// the API server itself owns (resourceVersion, uid). Use it for objects
+// we fully own and that have no server-assigned spec fields worth
+// preserving, such as ClusterRole/ClusterRoleBinding/ServiceAccount.
+type Override struct{}
+
+func (Override) Apply(current, desired runtime.Object) (runtime.Object, error) {
+	currentMeta, err := meta.Accessor(current)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get meta accessor for current object: %s", err)
+	}
+
+	desiredMeta, err := meta.Accessor(desired)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get meta accessor for desired object: %s", err)
+	}
+
+	desiredMeta.SetResourceVersion(currentMeta.GetResourceVersion())
+	desiredMeta.SetUID(currentMeta.GetUID())
+
+	return desired, nil
+}
+
+// Recreate signals that the object must be deleted and re-created rather
+// than updated in place, for kinds whose spec contains immutable fields
+// (e.g. Service.spec.clusterIP family, Job.spec.selector) where an
+// in-place PUT would be rejected by the API server. Apply itself changes
+// nothing and returns ErrRecreateRequired. ApplyTemplate does not delete
+// or re-create on that error; it reports it as a failed apply.
+type Recreate struct{}
+
+// ErrRecreateRequired is returned by Recreate.Apply to signal that the
+// object must be deleted and re-created instead of updated.
+var ErrRecreateRequired = fmt.Errorf("object must be recreated")
+
+func (Recreate) Apply(current, desired runtime.Object) (runtime.Object, error) {
+	return nil, ErrRecreateRequired
+}
+
+// MergeMetadataOnly copies the desired object's labels, annotations and
+// owner references onto the current object but leaves every other field
+// untouched. It is the right strategy for objects whose spec is
+// populated by something other than us (e.g. a Route's status, or a
+// Secret whose data is written by a controller we don't own).
+type MergeMetadataOnly struct{}
+
+func (MergeMetadataOnly) Apply(current, desired runtime.Object) (runtime.Object, error) {
+	currentMeta, err := meta.Accessor(current)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get meta accessor for current object: %s", err)
+	}
+
+	desiredMeta, err := meta.Accessor(desired)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get meta accessor for desired object: %s", err)
+	}
+
+	currentMeta.SetLabels(desiredMeta.GetLabels())
+	currentMeta.SetAnnotations(desiredMeta.GetAnnotations())
+	currentMeta.SetOwnerReferences(desiredMeta.GetOwnerReferences())
+
+	return current, nil
+}
+
+// PatchSpec copies metadata like MergeMetadataOnly, then replaces the
+// current object's Spec field with the desired object's Spec, via the
+// SpecSetter interface when both objects implement it and by setting
+// the Spec struct field directly otherwise. It is the common case for
+// our own workloads (Deployment, DaemonSet, Service) where we own the
+// whole spec but want to preserve server-assigned metadata and status.
+type PatchSpec struct{}
+
+// SpecSetter lets a type control how PatchSpec reads and replaces its
+// spec. client-go's typed objects don't implement it; PatchSpec copies
+// their exported Spec field instead.
+type SpecSetter interface {
+	GetSpec() interface{}
+	SetSpec(interface{})
+}
+
+func (PatchSpec) Apply(current, desired runtime.Object) (runtime.Object, error) {
+	if _, err := (MergeMetadataOnly{}).Apply(current, desired); err != nil {
+		return nil, err
+	}
+
+	cs, csOK := current.(SpecSetter)
+	ds, dsOK := desired.(SpecSetter)
+	if csOK && dsOK {
+		cs.SetSpec(ds.GetSpec())
+		return current, nil
+	}
+
+	cv, dv := reflect.ValueOf(current), reflect.ValueOf(desired)
+	if cv.Kind() != reflect.Ptr || cv.Type() != dv.Type() {
+		return nil, fmt.Errorf("cannot copy Spec from %T onto %T", desired, current)
+	}
+	cspec, dspec := cv.Elem().FieldByName("Spec"), dv.Elem().FieldByName("Spec")
+	if !cspec.IsValid() || !cspec.CanSet() {
+		return nil, fmt.Errorf("%T has no settable Spec field", current)
+	}
+	cspec.Set(dspec)
+
+	return current, nil
+}
@@ -0,0 +1,452 @@
+package resource
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"text/template"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kmeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsapi "github.com/openshift/api/apps/v1"
+	configapi "github.com/openshift/api/config/v1"
+	routeapi "github.com/openshift/api/route/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/certrotation"
+	"github.com/openshift/cluster-image-registry-operator/pkg/operator/strategy"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+	"github.com/openshift/cluster-image-registry-operator/pkg/storage"
+)
+
+//go:embed assets/*.yaml
+var assets embed.FS
+
+func init() {
+	utilruntime.Must(appsapi.AddToScheme(scheme.Scheme))
+	utilruntime.Must(configapi.AddToScheme(scheme.Scheme))
+	utilruntime.Must(routeapi.AddToScheme(scheme.Scheme))
+}
+
+// strategies maps an asset name to the Strategy ApplyTemplate must merge
+// it with, kept as a table rather than YAML front-matter so the
+// manifests stay plain and diffable.
+var strategies = map[string]strategy.Strategy{
+	"clusterrole.yaml":        strategy.Override{},
+	"clusterrolebinding.yaml": strategy.Override{},
+	"serviceaccount.yaml":     strategy.Override{},
+	"configmap.yaml":          strategy.MergeMetadataOnly{},
+	"service.yaml":            strategy.PatchSpec{},
+	"imageconfig.yaml":        strategy.MergeMetadataOnly{},
+	"deployment.yaml":         strategy.PatchSpec{},
+	"deploymentconfig.yaml":   strategy.PatchSpec{},
+	"route.yaml":              strategy.MergeMetadataOnly{},
+}
+
+// templateData is the root object every asset under assets/*.yaml is
+// rendered with.
+type templateData struct {
+	CR *regopapi.ImageRegistry
+	P  *parameters.Globals
+	// TLSChecksum is the digest of the certrotation-managed CA, serving
+	// certificate and CA bundle, set only when rendering an asset whose
+	// pod template needs to roll out on rotation (see tlsChecksumAssets).
+	TLSChecksum string
+	// StorageSecretChecksum is storage.Checksum's digest of the storage
+	// credentials Secret, set for the same assets as TLSChecksum: the pod
+	// spec only ever references that Secret via ValueFrom, so nothing
+	// about a credential rotation otherwise changes the Deployment/
+	// DeploymentConfig object ApplyTemplate's checksum is computed over.
+	StorageSecretChecksum string
+	// ProxyChecksum is proxyChecksum's digest of cr.Spec.Proxy.Upstreams'
+	// credentials and, for multiple upstreams, ProxyConfigMap's rendered
+	// config.yml, set for the same assets as TLSChecksum for the same
+	// reason: injectProxyConfig's env vars and volumes don't otherwise
+	// register with ApplyTemplate's checksum.
+	ProxyChecksum string
+}
+
+// checksumAssets are the assets whose pod template carries a TLSChecksum
+// and StorageSecretChecksum annotation, so a certificate rotation or a
+// storage credentials rotation each force a rollout.
+var checksumAssets = map[string]bool{
+	"deployment.yaml":       true,
+	"deploymentconfig.yaml": true,
+}
+
+// LoadRuntimeObject renders the named asset as a text/template against
+// cr and p, then decodes the result through the API machinery scheme.
+func LoadRuntimeObject(name string, cr *regopapi.ImageRegistry, p *parameters.Globals) (runtime.Object, error) {
+	raw, err := assets.ReadFile("assets/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown asset %q: %s", name, err)
+	}
+
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse asset %q: %s", name, err)
+	}
+
+	data := templateData{CR: cr, P: p}
+	if checksumAssets[name] {
+		checksum, err := certrotation.Checksum(p.Deployment.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compute TLS checksum for asset %q: %s", name, err)
+		}
+		data.TLSChecksum = checksum
+
+		storageChecksum, err := storage.Checksum(p.Deployment.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compute storage secret checksum for asset %q: %s", name, err)
+		}
+		data.StorageSecretChecksum = storageChecksum
+
+		proxyChecksum, err := proxyChecksum(cr, p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compute proxy checksum for asset %q: %s", name, err)
+		}
+		data.ProxyChecksum = proxyChecksum
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("unable to render asset %q: %s", name, err)
+	}
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(buf.Bytes(), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode asset %q: %s", name, err)
+	}
+
+	return obj, nil
+}
+
+// loadTemplate is LoadRuntimeObject plus the owner reference and merge
+// Strategy every generator below needs, so each one is a one-liner.
+func loadTemplate(name string, cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	obj, err := LoadRuntimeObject(name, cr, p)
+	if err != nil {
+		return Template{}, err
+	}
+
+	strat, ok := strategies[name]
+	if !ok {
+		return Template{}, fmt.Errorf("no strategy registered for asset %q", name)
+	}
+
+	accessor, err := kmeta.Accessor(obj)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to get meta accessor for asset %q: %s", name, err)
+	}
+	accessor.SetOwnerReferences(append(accessor.GetOwnerReferences(), ownerRefFor(cr)))
+
+	return Template{Object: obj, Strategy: strat}, nil
+}
+
+func ownerRefFor(cr *regopapi.ImageRegistry) metav1.OwnerReference {
+	blockOwnerDeletion := true
+	isController := true
+	return metav1.OwnerReference{
+		APIVersion:         "imageregistry.operator.openshift.io/v1alpha1",
+		Kind:               "ImageRegistry",
+		Name:               cr.GetName(),
+		UID:                cr.GetUID(),
+		BlockOwnerDeletion: &blockOwnerDeletion,
+		Controller:         &isController,
+	}
+}
+
+// ClusterRole, ClusterRoleBinding, ServiceAccount, ConfigMap, Service,
+// ImageConfig, Deployment and DeploymentConfig are TemplateGenerators
+// backed by the YAML assets above, so that reviewing an RBAC or manifest
+// change is a YAML diff rather than a Go diff.
+func ClusterRole(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	return loadTemplate("clusterrole.yaml", cr, p)
+}
+
+func ClusterRoleBinding(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	return loadTemplate("clusterrolebinding.yaml", cr, p)
+}
+
+func ServiceAccount(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	tmpl, err := loadTemplate("serviceaccount.yaml", cr, p)
+	if err != nil {
+		return Template{}, err
+	}
+	addWorkloadIdentityAnnotations(tmpl.Object.(metav1.Object), cr)
+	return tmpl, nil
+}
+
+// addWorkloadIdentityAnnotations stamps the cloud-specific annotation
+// that binds this ServiceAccount to an external identity (IAM role,
+// workload identity, ...), when the configured storage backend asks for
+// one. Each cloud's workload-identity mechanism discovers the binding by
+// annotation on the same ServiceAccount the registry pod already runs
+// as, rather than anything in the pod spec itself - unlike
+// injectStorageDriver's env/volume wiring, which S3 and Azure also need,
+// GCS workload identity federation is annotation-only: GKE's metadata
+// server exchanges the pod's own token for Google credentials
+// transparently.
+func addWorkloadIdentityAnnotations(accessor metav1.Object, cr *regopapi.ImageRegistry) {
+	switch {
+	case cr.Spec.Storage.S3 != nil && cr.Spec.Storage.S3.RoleARN != "":
+		setAnnotation(accessor, "eks.amazonaws.com/role-arn", cr.Spec.Storage.S3.RoleARN)
+	case cr.Spec.Storage.Azure != nil && cr.Spec.Storage.Azure.ClientID != "":
+		setAnnotation(accessor, "azure.workload.identity/client-id", cr.Spec.Storage.Azure.ClientID)
+	case cr.Spec.Storage.GCS != nil && cr.Spec.Storage.GCS.WorkloadIdentity != "":
+		setAnnotation(accessor, "iam.gke.io/gcp-service-account", cr.Spec.Storage.GCS.WorkloadIdentity)
+	}
+}
+
+func setAnnotation(accessor metav1.Object, key, value string) {
+	annotations := accessor.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	accessor.SetAnnotations(annotations)
+}
+
+func ConfigMap(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	return loadTemplate("configmap.yaml", cr, p)
+}
+
+func Service(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	return loadTemplate("service.yaml", cr, p)
+}
+
+func ImageConfig(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	return loadTemplate("imageconfig.yaml", cr, p)
+}
+
+func Deployment(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	tmpl, err := loadTemplate("deployment.yaml", cr, p)
+	if err != nil {
+		return Template{}, err
+	}
+	if err := injectStorageDriver(cr, p, tmpl.Object); err != nil {
+		return Template{}, err
+	}
+	if err := augmentPodSpec(cr, p, &tmpl.Object.(*appsv1.Deployment).Spec.Template.Spec); err != nil {
+		return Template{}, err
+	}
+	if err := injectProxyConfig(cr, &tmpl.Object.(*appsv1.Deployment).Spec.Template.Spec); err != nil {
+		return Template{}, err
+	}
+	return tmpl, nil
+}
+
+// PruneStaleWorkload deletes whichever of DeploymentConfig/Deployment
+// isn't selected by cr.Spec.ManagementStrategy, so flipping that field
+// doesn't leave the previously-active kind running alongside the new
+// one. It's a no-op if the stale kind was never created.
+func PruneStaleWorkload(cr *regopapi.ImageRegistry, p *parameters.Globals) error {
+	var stale runtime.Object
+	if cr.Spec.ManagementStrategy == regopapi.ManagementStrategyDeployment {
+		stale = &appsapi.DeploymentConfig{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps.openshift.io/v1", Kind: "DeploymentConfig"},
+			ObjectMeta: metav1.ObjectMeta{Name: p.Deployment.Name, Namespace: p.Deployment.Namespace},
+		}
+	} else {
+		stale = &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: p.Deployment.Name, Namespace: p.Deployment.Namespace},
+		}
+	}
+
+	if err := sdk.Delete(stale); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to prune stale %s: %s", stale.GetObjectKind().GroupVersionKind().Kind, err)
+	}
+	return nil
+}
+
+func DeploymentConfig(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	tmpl, err := loadTemplate("deploymentconfig.yaml", cr, p)
+	if err != nil {
+		return Template{}, err
+	}
+	if err := injectStorageDriver(cr, p, tmpl.Object); err != nil {
+		return Template{}, err
+	}
+	if err := augmentPodSpec(cr, p, &tmpl.Object.(*appsapi.DeploymentConfig).Spec.Template.Spec); err != nil {
+		return Template{}, err
+	}
+	if err := injectProxyConfig(cr, &tmpl.Object.(*appsapi.DeploymentConfig).Spec.Template.Spec); err != nil {
+		return Template{}, err
+	}
+	return tmpl, nil
+}
+
+// injectStorageDriver adds the configured storage.Driver's env vars and
+// volume/mount (see storage.Driver.GenerateEnv/GenerateVolume) onto the
+// registry container of a freshly decoded Deployment or DeploymentConfig.
+// Before this, the only thing the storage driver contributed to the
+// object graph was the image-registry-private-configuration Secret built
+// by Secret above; nothing actually mounted it or set the
+// REGISTRY_STORAGE_* env vars the registry binary reads, so every backend
+// but the filesystem default was silently inert.
+func injectStorageDriver(cr *regopapi.ImageRegistry, p *parameters.Globals, obj runtime.Object) error {
+	var podSpec *corev1.PodSpec
+	switch v := obj.(type) {
+	case *appsv1.Deployment:
+		podSpec = &v.Spec.Template.Spec
+	case *appsapi.DeploymentConfig:
+		podSpec = &v.Spec.Template.Spec
+	default:
+		return fmt.Errorf("injectStorageDriver: unsupported object type %T", obj)
+	}
+
+	driver, err := storage.NewDriver(cr.Name, p.Deployment.Namespace, &cr.Spec.Storage)
+	if err != nil {
+		return fmt.Errorf("unable to create storage driver: %s", err)
+	}
+
+	env, err := driver.GenerateEnv()
+	if err != nil {
+		return fmt.Errorf("unable to generate storage env vars: %s", err)
+	}
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, env...)
+
+	vol, mount, err := driver.GenerateVolume()
+	if err != nil {
+		return fmt.Errorf("unable to generate storage volume: %s", err)
+	}
+	if vol != nil {
+		podSpec.Volumes = append(podSpec.Volumes, *vol)
+	}
+	if mount != nil {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, *mount)
+	}
+
+	return nil
+}
+
+// Secret is deliberately not one of the templated assets above: its
+// contents come from the configured storage driver rather than the CR
+// or parameters.Globals, so there's nothing generic for a static
+// manifest to express.
+func Secret(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "image-registry-private-configuration",
+			Namespace: p.Deployment.Namespace,
+			Labels:    p.Deployment.Labels,
+		},
+	}
+
+	driver, err := storage.NewDriver(cr.Name, p.Deployment.Namespace, &cr.Spec.Storage)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to create storage driver: %s", err)
+	}
+
+	driverSecret, err := driver.GenerateSecret(p.Deployment.Namespace)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to generate storage secret: %s", err)
+	}
+	if driverSecret != nil {
+		secret.StringData = driverSecret.StringData
+	}
+
+	accessor, err := kmeta.Accessor(secret)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to get meta accessor for %s: %s", secret.Name, err)
+	}
+	accessor.SetOwnerReferences(append(accessor.GetOwnerReferences(), ownerRefFor(cr)))
+
+	return Template{Object: secret, Strategy: strategy.Override{}}, nil
+}
+
+// GetRouteGenerators returns one TemplateGenerator per Route the
+// operator manages, keyed by the Route's name so callers (see
+// syncRoutes in pkg/operator) can look up a single Route by name
+// without regenerating all of them. There is
+// always the default route exposing the registry service, plus one per
+// entry in cr.Spec.Routes for operators that need a custom hostname or
+// their own TLS certificate.
+func GetRouteGenerators(cr *regopapi.ImageRegistry, p *parameters.Globals) map[string]TemplateGenerator {
+	defaultName := p.Service.Name + "-default"
+	gens := map[string]TemplateGenerator{
+		defaultName: func(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+			return loadTemplate("route.yaml", cr, p)
+		},
+	}
+
+	for _, rc := range cr.Spec.Routes {
+		rc := rc
+		gens[rc.Name] = func(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+			return customRoute(cr, p, rc)
+		}
+	}
+
+	return gens
+}
+
+// customRoute builds the Route for one entry of cr.Spec.Routes. Unlike
+// route.yaml, its Hostname and TerminationPolicy come from the CR rather
+// than parameters.Globals, and a SecretName has to be read back from the
+// cluster to populate the TLS certificate and key, so - like Secret above
+// - it is hand-written rather than templated.
+func customRoute(cr *regopapi.ImageRegistry, p *parameters.Globals, rc regopapi.RouteConfig) (Template, error) {
+	termination := routeapi.TLSTerminationReencrypt
+	if rc.TerminationPolicy != "" {
+		termination = routeapi.TLSTerminationType(rc.TerminationPolicy)
+	}
+
+	route := &routeapi.Route{
+		TypeMeta: metav1.TypeMeta{APIVersion: "route.openshift.io/v1", Kind: "Route"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rc.Name,
+			Namespace: p.Deployment.Namespace,
+			Labels:    p.Deployment.Labels,
+		},
+		Spec: routeapi.RouteSpec{
+			Host: rc.Hostname,
+			To: routeapi.RouteTargetReference{
+				Kind: "Service",
+				Name: p.Service.Name,
+			},
+			Port: &routeapi.RoutePort{
+				TargetPort: intstr.FromString("https"),
+			},
+			TLS: &routeapi.TLSConfig{
+				Termination: termination,
+			},
+		},
+	}
+
+	// A passthrough route forwards the encrypted connection straight to
+	// the backend, so the Route API rejects spec.tls.certificate/key/
+	// caCertificate on it outright - only the reencrypt/edge terminations
+	// that decrypt on the router can carry them.
+	if rc.SecretName != "" && termination != routeapi.TLSTerminationPassthrough {
+		secret := &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: rc.SecretName, Namespace: p.Deployment.Namespace},
+		}
+		if err := sdk.Get(secret); err != nil {
+			return Template{}, fmt.Errorf("unable to get TLS secret %s for route %s: %s", rc.SecretName, rc.Name, err)
+		}
+		route.Spec.TLS.Certificate = string(secret.Data["tls.crt"])
+		route.Spec.TLS.Key = string(secret.Data["tls.key"])
+		route.Spec.TLS.CACertificate = string(secret.Data["ca.crt"])
+	}
+
+	accessor, err := kmeta.Accessor(route)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to get meta accessor for route %s: %s", rc.Name, err)
+	}
+	accessor.SetOwnerReferences(append(accessor.GetOwnerReferences(), ownerRefFor(cr)))
+
+	return Template{Object: route, Strategy: strategy.MergeMetadataOnly{}}, nil
+}
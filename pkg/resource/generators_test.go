@@ -0,0 +1,73 @@
+package resource
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	routeapi "github.com/openshift/api/route/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+func testParams() *parameters.Globals {
+	p := &parameters.Globals{}
+	p.Deployment.Namespace = "openshift-image-registry"
+	p.Deployment.Labels = map[string]string{"docker-registry": "default"}
+	p.Service.Name = "image-registry"
+	return p
+}
+
+// TestCustomRoutePassthroughSkipsTLSSecret covers the chunk1-6 fix:
+// a passthrough route must not carry spec.tls.certificate/key/caCertificate,
+// since the Route API rejects them outright on that termination. This also
+// means customRoute must not try to fetch SecretName's Secret at all for a
+// passthrough route - otherwise this test would fail before ever reaching
+// the assertions below, since nothing in this test registers a fake client
+// for sdk.Get to use.
+func TestCustomRoutePassthroughSkipsTLSSecret(t *testing.T) {
+	cr := &regopapi.ImageRegistry{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+	p := testParams()
+
+	rc := regopapi.RouteConfig{
+		Name:              "custom",
+		Hostname:          "registry.example.com",
+		TerminationPolicy: string(routeapi.TLSTerminationPassthrough),
+		SecretName:        "custom-tls",
+	}
+
+	tmpl, err := customRoute(cr, p, rc)
+	if err != nil {
+		t.Fatalf("customRoute returned an error: %s", err)
+	}
+
+	route, ok := tmpl.Object.(*routeapi.Route)
+	if !ok {
+		t.Fatalf("customRoute returned a %T, not *routeapi.Route", tmpl.Object)
+	}
+
+	if route.Spec.TLS.Certificate != "" || route.Spec.TLS.Key != "" || route.Spec.TLS.CACertificate != "" {
+		t.Errorf("passthrough route must not carry a certificate/key/CA, got %+v", route.Spec.TLS)
+	}
+}
+
+// TestCustomRouteDefaultTermination covers the case with no
+// TerminationPolicy and no SecretName set: it should default to
+// reencrypt and never attempt to fetch a TLS secret.
+func TestCustomRouteDefaultTermination(t *testing.T) {
+	cr := &regopapi.ImageRegistry{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+	p := testParams()
+
+	rc := regopapi.RouteConfig{Name: "default", Hostname: "registry.example.com"}
+
+	tmpl, err := customRoute(cr, p, rc)
+	if err != nil {
+		t.Fatalf("customRoute returned an error: %s", err)
+	}
+
+	route := tmpl.Object.(*routeapi.Route)
+	if route.Spec.TLS.Termination != routeapi.TLSTerminationReencrypt {
+		t.Errorf("expected default termination %q, got %q", routeapi.TLSTerminationReencrypt, route.Spec.TLS.Termination)
+	}
+}
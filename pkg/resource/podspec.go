@@ -0,0 +1,340 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	projectapi "github.com/openshift/api/project/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+const (
+	supplementalGroupsAnnotation = "openshift.io/sa.scc.supplemental-groups"
+
+	// uidRangeAnnotation is the namespace annotation the cluster's
+	// openshift.io/UIDRange SCC allocator stamps every project with,
+	// analogous to supplementalGroupsAnnotation for group IDs.
+	uidRangeAnnotation = "openshift.io/sa.scc.uid-range"
+)
+
+// augmentPodSpec fills in everything deployment.yaml/deploymentconfig.yaml
+// can't express as a static manifest because it is either CR-configurable
+// with a non-trivial default (probes, resources) or derived from cluster
+// state the template has no way to look up (the namespace's SCC-allocated
+// UID/FSGroup range). It runs after injectStorageDriver, on the same
+// decoded object, so it's free to overwrite the template's placeholder
+// probes and to append to whatever injectStorageDriver already added.
+func augmentPodSpec(cr *regopapi.ImageRegistry, p *parameters.Globals, podSpec *corev1.PodSpec) error {
+	container := &podSpec.Containers[0]
+
+	container.LivenessProbe = generateLivenessProbeConfig(cr, p)
+	container.ReadinessProbe = generateReadinessProbeConfig(cr, p)
+	container.Resources = generateResourceRequirements(cr)
+	container.Env = append(container.Env, generateCacheEnv(cr)...)
+
+	if cr.Spec.MaintenanceReadOnly {
+		// Lets an operator drive a rolling Redis cache-node restart (or any
+		// other maintenance that would otherwise surface as backend errors)
+		// by flipping the registry read-only first, rather than taking
+		// writes while the cache layer it depends on is unstable.
+		container.Env = append(container.Env, corev1.EnvVar{Name: "REGISTRY_STORAGE_MAINTENANCE_READONLY_ENABLED", Value: "true"})
+	}
+
+	if err := validateCacheConfiguration(cr); err != nil {
+		return err
+	}
+
+	securityContext, err := generateSecurityContext(cr, cr.Namespace)
+	if err != nil {
+		return fmt.Errorf("unable to generate security context: %s", err)
+	}
+	podSpec.SecurityContext = securityContext
+	container.SecurityContext = generateContainerSecurityContext(cr)
+
+	if cr.Spec.PodSecurity == regopapi.PodSecurityRestricted {
+		// A read-only root filesystem breaks distribution's default use of
+		// the storage root for temp files, so give it back a writable
+		// scratch directory without reopening the rest of the root FS.
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         "registry-tmp",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "registry-tmp", MountPath: "/var/lib/registry"})
+	}
+
+	podSpec.NodeSelector = cr.Spec.NodeSelector
+	podSpec.Tolerations = cr.Spec.Tolerations
+	podSpec.Affinity = cr.Spec.Affinity
+	podSpec.TopologySpreadConstraints = cr.Spec.TopologySpreadConstraints
+	podSpec.ImagePullSecrets = cr.Spec.ImagePullSecrets
+	podSpec.PriorityClassName = cr.Spec.PriorityClassName
+
+	return nil
+}
+
+// generateProbeConfig is the healthz-route default shared by
+// generateLivenessProbeConfig/generateReadinessProbeConfig when the CR
+// doesn't override a probe.
+func generateProbeConfig(p *parameters.Globals) *corev1.Probe {
+	var scheme corev1.URIScheme
+	if p.Container.UseTLS {
+		scheme = corev1.URISchemeHTTPS
+	}
+	return &corev1.Probe{
+		TimeoutSeconds: int32(p.Healthz.TimeoutSeconds),
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Scheme: scheme,
+				Path:   p.Healthz.Route,
+				Port:   intstr.FromInt(p.Container.Port),
+			},
+		},
+	}
+}
+
+// generateLivenessProbeConfig returns cr.Spec.LivenessProbe verbatim when
+// set, so operators can retune timeouts/thresholds for their environment;
+// otherwise the healthz-route default, with its historical
+// InitialDelaySeconds: 10.
+func generateLivenessProbeConfig(cr *regopapi.ImageRegistry, p *parameters.Globals) *corev1.Probe {
+	if cr.Spec.LivenessProbe != nil {
+		return cr.Spec.LivenessProbe.DeepCopy()
+	}
+
+	probeConfig := generateProbeConfig(p)
+	probeConfig.InitialDelaySeconds = 10
+
+	return probeConfig
+}
+
+// generateReadinessProbeConfig is generateLivenessProbeConfig's
+// counterpart for cr.Spec.ReadinessProbe.
+func generateReadinessProbeConfig(cr *regopapi.ImageRegistry, p *parameters.Globals) *corev1.Probe {
+	if cr.Spec.ReadinessProbe != nil {
+		return cr.Spec.ReadinessProbe.DeepCopy()
+	}
+
+	return generateProbeConfig(p)
+}
+
+// defaultContainerResources mirrors the resource requests (no limits) the
+// registry container has always started with; cr.Spec.Resources overrides
+// it wholesale when set, since partial merges would leave it unclear which
+// defaults are still in effect.
+var defaultContainerResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("256Mi"),
+	},
+}
+
+func generateResourceRequirements(cr *regopapi.ImageRegistry) corev1.ResourceRequirements {
+	if cr.Spec.Resources != nil {
+		return *cr.Spec.Resources
+	}
+	return defaultContainerResources
+}
+
+// Redis connection pool defaults, kept as named constants rather than
+// inlined literals so they don't drift from each other if one gets tuned.
+const (
+	redisPoolMaxIdle     = "10"
+	redisPoolMaxActive   = "100"
+	redisPoolIdleTimeout = "300s"
+	redisDialTimeout     = "10s"
+)
+
+// generateCacheEnv translates cr.Spec.Cache into the registry's
+// REGISTRY_STORAGE_CACHE_BLOBDESCRIPTOR env var and, for CacheTypeRedis,
+// the REGISTRY_REDIS_* env vars distribution's Redis cache driver reads.
+// The zero value (Spec.Cache unset) keeps the historical inmemory default
+// so existing CRs are unaffected.
+func generateCacheEnv(cr *regopapi.ImageRegistry) []corev1.EnvVar {
+	cacheType := cr.Spec.Cache.Type
+	if cacheType == "" {
+		cacheType = regopapi.CacheTypeInMemory
+	}
+
+	if cacheType == regopapi.CacheTypeNone {
+		return nil
+	}
+
+	if cacheType != regopapi.CacheTypeRedis {
+		return []corev1.EnvVar{
+			{Name: "REGISTRY_STORAGE_CACHE_BLOBDESCRIPTOR", Value: "inmemory"},
+		}
+	}
+
+	redis := cr.Spec.Cache.Redis
+
+	addr := redis.Address
+	if len(redis.Sentinels) > 0 {
+		addr = strings.Join(redis.Sentinels, ",")
+	}
+	if redis.TLS {
+		addr = "rediss://" + addr
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE_CACHE_BLOBDESCRIPTOR", Value: "redis"},
+		{Name: "REGISTRY_REDIS_ADDR", Value: addr},
+		{Name: "REGISTRY_REDIS_DB", Value: fmt.Sprintf("%d", redis.DB)},
+		{Name: "REGISTRY_REDIS_POOL_MAXIDLE", Value: redisPoolMaxIdle},
+		{Name: "REGISTRY_REDIS_POOL_MAXACTIVE", Value: redisPoolMaxActive},
+		{Name: "REGISTRY_REDIS_POOL_IDLETIMEOUT", Value: redisPoolIdleTimeout},
+		{Name: "REGISTRY_REDIS_DIALTIMEOUT", Value: redisDialTimeout},
+	}
+
+	if redis.PasswordSecretRef != "" {
+		env = append(env, corev1.EnvVar{
+			Name: "REGISTRY_REDIS_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: redis.PasswordSecretRef},
+					Key:                  "password",
+				},
+			},
+		})
+	}
+
+	return env
+}
+
+// validateCacheConfiguration rejects a multi-replica rollout that would
+// run each pod's blob-descriptor cache independently: with
+// CacheTypeInMemory and Replicas > 1, different replicas can observe a
+// manifest/blob as present or absent depending on which pod last cached
+// it, serving stale reads under concurrent pushes. Surfaced as an error so
+// the caller degrades rather than deploying a cache setup known to be
+// incoherent.
+func validateCacheConfiguration(cr *regopapi.ImageRegistry) error {
+	cacheType := cr.Spec.Cache.Type
+	if cacheType == "" {
+		cacheType = regopapi.CacheTypeInMemory
+	}
+
+	if cacheType == regopapi.CacheTypeInMemory && cr.Spec.Replicas > 1 {
+		return fmt.Errorf("Spec.Cache.Type InMemory is not coherent across Replicas=%d; use CacheTypeRedis or run a single replica", cr.Spec.Replicas)
+	}
+
+	return nil
+}
+
+// generateSecurityContext derives the pod's SecurityContext from the
+// namespace's SCC-allocated ranges, gated by cr.Spec.PodSecurity:
+// PodSecurityPrivileged skips it entirely (the pod runs under the
+// privileged SCC and doesn't need an fsGroup); PodSecurityLegacy (and the
+// zero value, for upgrades that haven't opted in yet) keeps the
+// historical FSGroup-only behavior; PodSecurityRestricted additionally
+// pins RunAsUser/RunAsNonRoot so the pod satisfies restricted-v2.
+func generateSecurityContext(cr *regopapi.ImageRegistry, namespace string) (*corev1.PodSecurityContext, error) {
+	if cr.Spec.PodSecurity == regopapi.PodSecurityPrivileged {
+		return nil, nil
+	}
+
+	ns := &projectapi.Project{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "project.openshift.io/v1",
+			Kind:       "Project",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+		},
+	}
+	if err := sdk.Get(ns); err != nil {
+		return nil, err
+	}
+
+	sgrange, ok := ns.Annotations[supplementalGroupsAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q doesn't have annotation %s", namespace, supplementalGroupsAnnotation)
+	}
+
+	idx := strings.Index(sgrange, "/")
+	if idx == -1 {
+		return nil, fmt.Errorf("annotation %s in namespace %q doesn't contain '/'", supplementalGroupsAnnotation, namespace)
+	}
+
+	gid, err := strconv.ParseInt(sgrange[:idx], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse annotation %s in namespace %q: %s", supplementalGroupsAnnotation, namespace, err)
+	}
+
+	securityContext := &corev1.PodSecurityContext{
+		FSGroup: &gid,
+	}
+
+	if cr.Spec.PodSecurity != regopapi.PodSecurityRestricted {
+		return securityContext, nil
+	}
+
+	uid, err := podSecurityRunAsUser(ns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine a UID to run as: %s", err)
+	}
+
+	runAsNonRoot := true
+	securityContext.RunAsUser = &uid
+	securityContext.RunAsNonRoot = &runAsNonRoot
+
+	return securityContext, nil
+}
+
+// podSecurityRunAsUser picks the first UID in the namespace's allocated
+// openshift.io/sa.scc.uid-range. A namespace without the annotation (e.g.
+// one that isn't SCC-managed) can't satisfy PodSecurityRestricted at all.
+// The error is returned through generateSecurityContext and augmentPodSpec
+// to the template generator's caller, which fails the reconcile before any
+// object is applied; no condition is set on the CR for it.
+func podSecurityRunAsUser(ns *projectapi.Project) (int64, error) {
+	uidrange, ok := ns.Annotations[uidRangeAnnotation]
+	if !ok {
+		return 0, fmt.Errorf("namespace %q doesn't have annotation %s", ns.Name, uidRangeAnnotation)
+	}
+
+	idx := strings.Index(uidrange, "/")
+	if idx == -1 {
+		return 0, fmt.Errorf("annotation %s in namespace %q doesn't contain '/'", uidRangeAnnotation, ns.Name)
+	}
+
+	uid, err := strconv.ParseInt(uidrange[:idx], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse annotation %s in namespace %q: %s", uidRangeAnnotation, ns.Name, err)
+	}
+
+	return uid, nil
+}
+
+// generateContainerSecurityContext returns the registry container's
+// SecurityContext when cr.Spec.PodSecurity is PodSecurityRestricted,
+// locking it down to the restricted-v2 SCC's requirements; nil otherwise,
+// so upgrading clusters aren't affected until they opt in.
+func generateContainerSecurityContext(cr *regopapi.ImageRegistry) *corev1.SecurityContext {
+	if cr.Spec.PodSecurity != regopapi.PodSecurityRestricted {
+		return nil
+	}
+
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	kmeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/operator/strategy"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+// proxyConfigMapName holds the generated multi-upstream proxy config.yml
+// for the mount every pod template mounts over
+// /etc/docker/registry/config.yml once more than one upstream is
+// configured; see injectProxyConfig.
+const proxyConfigMapName = "image-registry-proxy-config"
+
+// proxyMountName turns an upstream's remote URL into a filesystem-safe
+// name for its per-upstream credentials mount, e.g.
+// https://registry-1.docker.io becomes registry-1-docker-io.
+func proxyMountName(remoteURL string) string {
+	name := remoteURL
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(name)
+}
+
+// injectProxyConfig wires cr.Spec.Proxy.Upstreams into podSpec's registry
+// container. A single upstream maps directly onto distribution's native
+// REGISTRY_PROXY_* env vars, with credentials pulled from the referenced
+// Secret via ValueFrom so they never appear inlined in the Deployment.
+// distribution itself only understands one upstream, so multiple entries
+// instead mount the ConfigMap ProxyConfigMap renders, listing every
+// mirror keyed by hostname with its own credential file paths, over
+// /etc/docker/registry/config.yml - replacing the env-var-only mode
+// entirely.
+func injectProxyConfig(cr *regopapi.ImageRegistry, podSpec *corev1.PodSpec) error {
+	upstreams := cr.Spec.Proxy.Upstreams
+	if len(upstreams) == 0 {
+		return nil
+	}
+
+	container := &podSpec.Containers[0]
+
+	if len(upstreams) == 1 {
+		u := upstreams[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "REGISTRY_PROXY_REMOTEURL", Value: u.RemoteURL})
+
+		if u.CredentialsSecretRef == "" {
+			return nil
+		}
+
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name: "REGISTRY_PROXY_USERNAME",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: u.CredentialsSecretRef},
+						Key:                  "username",
+					},
+				},
+			},
+			corev1.EnvVar{
+				Name: "REGISTRY_PROXY_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: u.CredentialsSecretRef},
+						Key:                  "password",
+					},
+				},
+			},
+		)
+
+		return nil
+	}
+
+	for _, u := range upstreams {
+		if u.CredentialsSecretRef == "" {
+			continue
+		}
+
+		hostname := proxyMountName(u.RemoteURL)
+		volName := "proxy-credentials-" + hostname
+		mountPath := "/etc/docker/registry/proxy-secrets/" + hostname
+
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         volName,
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: u.CredentialsSecretRef}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volName, MountPath: mountPath, ReadOnly: true})
+	}
+
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "registry-proxy-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: proxyConfigMapName}},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "registry-proxy-config", MountPath: "/etc/docker/registry/config.yml", SubPath: "config.yml"})
+
+	return nil
+}
+
+// proxyConfigData renders the multi-upstream config.yml ProxyConfigMap
+// mounts: a "proxy: mirrors:" stanza keyed by proxyMountName, pointing
+// each mirror at the usernamefile/passwordfile injectProxyConfig mounted
+// for it.
+func proxyConfigData(upstreams []regopapi.ProxyUpstream) string {
+	var config strings.Builder
+	config.WriteString("proxy:\n  mirrors:\n")
+
+	for _, u := range upstreams {
+		hostname := proxyMountName(u.RemoteURL)
+		fmt.Fprintf(&config, "    %s:\n      remoteurl: %s\n", hostname, u.RemoteURL)
+
+		if u.CredentialsSecretRef == "" {
+			continue
+		}
+		mountPath := "/etc/docker/registry/proxy-secrets/" + hostname
+		fmt.Fprintf(&config, "      usernamefile: %s/username\n      passwordfile: %s/password\n", mountPath, mountPath)
+	}
+
+	return config.String()
+}
+
+// ProxyConfigMap is the multi-upstream counterpart to Secret: its content
+// is built from cr.Spec.Proxy.Upstreams rather than loaded from a static
+// manifest, so it's hand-written like Secret/customRoute in generators.go rather
+// than templated. It's only added to Handler.GenerateTemplates' generator
+// list when more than one upstream is configured (see
+// Handler.GenerateTemplates), since a single upstream uses the
+// env-var-only mode instead and has nothing to put in a ConfigMap.
+func ProxyConfigMap(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error) {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      proxyConfigMapName,
+			Namespace: p.Deployment.Namespace,
+			Labels:    p.Deployment.Labels,
+		},
+		Data: map[string]string{"config.yml": proxyConfigData(cr.Spec.Proxy.Upstreams)},
+	}
+
+	accessor, err := kmeta.Accessor(cm)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to get meta accessor for %s: %s", cm.Name, err)
+	}
+	accessor.SetOwnerReferences(append(accessor.GetOwnerReferences(), ownerRefFor(cr)))
+
+	return Template{Object: cm, Strategy: strategy.Override{}}, nil
+}
+
+// proxyChecksum digests whatever injectProxyConfig's output actually
+// depends on - the single-upstream credentials Secret, or the
+// multi-upstream ConfigMap plus each mirror's credentials Secret - so
+// that rotating a proxy credential or editing Spec.Proxy.Upstreams forces
+// a rollout: like the storage secret, these are only ever referenced
+// from the pod spec via ValueFrom or a ConfigMap volume, neither of which
+// otherwise changes the Deployment/DeploymentConfig checksum.
+func proxyChecksum(cr *regopapi.ImageRegistry, p *parameters.Globals) (string, error) {
+	upstreams := cr.Spec.Proxy.Upstreams
+	if len(upstreams) == 0 {
+		return "", nil
+	}
+
+	refs := map[string]bool{}
+	for _, u := range upstreams {
+		if u.CredentialsSecretRef != "" {
+			refs[u.CredentialsSecretRef] = true
+		}
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: p.Deployment.Namespace}}
+		if err := sdk.Get(secret); err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("unable to get proxy credentials secret %s: %s", name, err)
+		}
+		h.Write([]byte(name))
+		h.Write(secret.Data["username"])
+		h.Write(secret.Data["password"])
+	}
+
+	if len(upstreams) > 1 {
+		h.Write([]byte(proxyConfigData(upstreams)))
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,122 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+)
+
+func testPodSpec() *corev1.PodSpec {
+	return &corev1.PodSpec{Containers: []corev1.Container{{Name: "registry"}}}
+}
+
+func TestProxyMountName(t *testing.T) {
+	tests := []struct {
+		remoteURL string
+		want      string
+	}{
+		{"https://registry-1.docker.io", "registry-1-docker-io"},
+		{"https://my-registry.example.com:5000", "my-registry-example-com-5000"},
+	}
+
+	for _, tt := range tests {
+		if got := proxyMountName(tt.remoteURL); got != tt.want {
+			t.Errorf("proxyMountName(%q) = %q, want %q", tt.remoteURL, got, tt.want)
+		}
+	}
+}
+
+func TestProxyConfigDataMultiUpstream(t *testing.T) {
+	upstreams := []regopapi.ProxyUpstream{
+		{RemoteURL: "https://registry-1.docker.io", CredentialsSecretRef: "docker-creds"},
+		{RemoteURL: "https://quay.io"},
+	}
+
+	data := proxyConfigData(upstreams)
+
+	if !strings.Contains(data, "registry-1-docker-io:") {
+		t.Errorf("expected config.yml to key the first mirror by its mount name, got:\n%s", data)
+	}
+	if !strings.Contains(data, "remoteurl: https://registry-1.docker.io") {
+		t.Errorf("expected config.yml to carry the first mirror's remoteurl, got:\n%s", data)
+	}
+	if !strings.Contains(data, "usernamefile:") || !strings.Contains(data, "passwordfile:") {
+		t.Errorf("expected credential file paths for the mirror with CredentialsSecretRef set, got:\n%s", data)
+	}
+	if strings.Count(data, "usernamefile:") != 1 {
+		t.Errorf("the upstream with no CredentialsSecretRef must not get credential file paths, got:\n%s", data)
+	}
+}
+
+func TestInjectProxyConfigSingleUpstream(t *testing.T) {
+	cr := &regopapi.ImageRegistry{
+		Spec: regopapi.ImageRegistrySpec{
+			Proxy: regopapi.ImageRegistryConfigProxy{
+				Upstreams: []regopapi.ProxyUpstream{
+					{RemoteURL: "https://registry-1.docker.io", CredentialsSecretRef: "docker-creds"},
+				},
+			},
+		},
+	}
+	podSpec := testPodSpec()
+
+	if err := injectProxyConfig(cr, podSpec); err != nil {
+		t.Fatalf("injectProxyConfig returned an error: %s", err)
+	}
+
+	env := map[string]bool{}
+	for _, e := range podSpec.Containers[0].Env {
+		env[e.Name] = true
+	}
+	for _, name := range []string{"REGISTRY_PROXY_REMOTEURL", "REGISTRY_PROXY_USERNAME", "REGISTRY_PROXY_PASSWORD"} {
+		if !env[name] {
+			t.Errorf("expected env var %s for a single upstream, got %+v", name, podSpec.Containers[0].Env)
+		}
+	}
+	if len(podSpec.Volumes) != 0 {
+		t.Errorf("a single upstream must not mount the multi-upstream ConfigMap, got volumes %+v", podSpec.Volumes)
+	}
+}
+
+func TestInjectProxyConfigMultiUpstream(t *testing.T) {
+	cr := &regopapi.ImageRegistry{
+		Spec: regopapi.ImageRegistrySpec{
+			Proxy: regopapi.ImageRegistryConfigProxy{
+				Upstreams: []regopapi.ProxyUpstream{
+					{RemoteURL: "https://registry-1.docker.io", CredentialsSecretRef: "docker-creds"},
+					{RemoteURL: "https://quay.io"},
+				},
+			},
+		},
+	}
+	podSpec := testPodSpec()
+
+	if err := injectProxyConfig(cr, podSpec); err != nil {
+		t.Fatalf("injectProxyConfig returned an error: %s", err)
+	}
+
+	for _, e := range podSpec.Containers[0].Env {
+		if strings.HasPrefix(e.Name, "REGISTRY_PROXY_") {
+			t.Errorf("multiple upstreams must not use the single-upstream env vars, found %s", e.Name)
+		}
+	}
+
+	var hasConfigVolume, hasCredsVolume bool
+	for _, v := range podSpec.Volumes {
+		if v.Name == "registry-proxy-config" {
+			hasConfigVolume = true
+		}
+		if v.Name == "proxy-credentials-registry-1-docker-io" {
+			hasCredsVolume = true
+		}
+	}
+	if !hasConfigVolume {
+		t.Errorf("expected the rendered config.yml ConfigMap to be mounted, got volumes %+v", podSpec.Volumes)
+	}
+	if !hasCredsVolume {
+		t.Errorf("expected a per-upstream credentials volume for the upstream with CredentialsSecretRef set, got volumes %+v", podSpec.Volumes)
+	}
+}
@@ -0,0 +1,76 @@
+// Package repo loads the operator's manifests from embedded YAML rather
+// than building them up in Go. Each asset is rendered as a text/template
+// with the caller-supplied data, decoded through the API machinery
+// scheme, and returned paired with the merge Strategy it must be applied
+// with. The assets are embedded into the binary at build time and read
+// only from there; changing a manifest means rebuilding the operator.
+package repo
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	routeapi "github.com/openshift/api/route/v1"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/operator/strategy"
+)
+
+//go:embed assets/*.yaml
+var assets embed.FS
+
+func init() {
+	utilruntime.Must(routeapi.AddToScheme(scheme.Scheme))
+}
+
+// Template is a manifest loaded from the repo together with the merge
+// Strategy it should be applied with.
+type Template struct {
+	Object   runtime.Object
+	Strategy strategy.Strategy
+}
+
+// strategies maps an asset name to the Strategy it must be applied
+// with. This is kept as a table, rather than front-matter inside the
+// YAML, so the manifests stay plain and diffable against upstream.
+var strategies = map[string]strategy.Strategy{
+	"serviceaccount.yaml": strategy.Override{},
+	"clusterrole.yaml":    strategy.Override{},
+}
+
+// Load renders the named asset as a text/template with data, decodes the
+// result through the API machinery scheme, and returns it paired with
+// its configured merge Strategy.
+func Load(name string, data interface{}) (Template, error) {
+	raw, err := assets.ReadFile("assets/" + name)
+	if err != nil {
+		return Template{}, fmt.Errorf("unknown asset %q: %s", name, err)
+	}
+
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to parse asset %q: %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return Template{}, fmt.Errorf("unable to render asset %q: %s", name, err)
+	}
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(buf.Bytes(), nil, nil)
+	if err != nil {
+		return Template{}, fmt.Errorf("unable to decode asset %q: %s", name, err)
+	}
+
+	strat, ok := strategies[name]
+	if !ok {
+		return Template{}, fmt.Errorf("no strategy registered for asset %q", name)
+	}
+
+	return Template{Object: obj, Strategy: strat}, nil
+}
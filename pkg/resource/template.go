@@ -0,0 +1,122 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	kmeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+
+	regopapi "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/operator/strategy"
+	"github.com/openshift/cluster-image-registry-operator/pkg/parameters"
+)
+
+// checksumAnnotation records the checksum of the object as last applied
+// by the operator, so ApplyTemplate can tell "nothing to do" apart from
+// "needs an update" without fetching and diffing the whole object.
+const checksumAnnotation = "imageregistry.operator.openshift.io/checksum"
+
+// TemplateGenerator builds the desired state of one object from the CR,
+// ready to be handed to ApplyTemplate. ClusterRole, ServiceAccount,
+// ConfigMap, Service, ImageConfig, Deployment and DeploymentConfig in
+// generators.go are all TemplateGenerators; GetRouteGenerators returns one per
+// configured Route.
+type TemplateGenerator func(cr *regopapi.ImageRegistry, p *parameters.Globals) (Template, error)
+
+// Template is a desired object paired with the Strategy ApplyTemplate
+// must use to merge it onto whatever is currently on the cluster.
+type Template struct {
+	Object   runtime.Object
+	Strategy strategy.Strategy
+}
+
+// Name identifies the template for logging: its GroupVersionKind plus
+// namespace/name, e.g. "v1, Kind=Service, Namespace=openshift-image-registry, Name=image-registry".
+func (t Template) Name() string {
+	gvk := t.Object.GetObjectKind().GroupVersionKind()
+
+	accessor, err := kmeta.Accessor(t.Object)
+	if err != nil {
+		return fmt.Sprintf("%s, %#+v", gvk, t.Object)
+	}
+
+	var name string
+	if namespace := accessor.GetNamespace(); namespace != "" {
+		name = fmt.Sprintf("Namespace=%s, ", namespace)
+	}
+	name += fmt.Sprintf("Name=%s", accessor.GetName())
+
+	return fmt.Sprintf("%s, %s", gvk, name)
+}
+
+func checksum(o interface{}) (string, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// ApplyTemplate creates tmpl.Object if it doesn't exist yet, or merges it
+// onto the current object via tmpl.Strategy otherwise. The checksum
+// annotation lets a normal apply short-circuit once the object already
+// matches what we want; force skips that short-circuit, which callers
+// need when the object itself hasn't changed but something it depends on
+// has.
+func ApplyTemplate(tmpl Template, force bool, modified *bool) error {
+	dgst, err := checksum(tmpl.Object)
+	if err != nil {
+		return fmt.Errorf("unable to generate checksum for %s: %s", tmpl.Name(), err)
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		current := tmpl.Object.DeepCopyObject()
+
+		err := sdk.Get(current)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to get %s: %s", tmpl.Name(), err)
+			}
+			err = sdk.Create(tmpl.Object)
+			*modified = err == nil
+			return err
+		}
+
+		currentMeta, err := kmeta.Accessor(current)
+		if err != nil {
+			return fmt.Errorf("unable to get meta accessor for current object: %s", err)
+		}
+
+		if !force {
+			curdgst, ok := currentMeta.GetAnnotations()[checksumAnnotation]
+			if ok && dgst == curdgst {
+				return nil
+			}
+		}
+
+		updated, err := tmpl.Strategy.Apply(current, tmpl.Object)
+		if err != nil {
+			return fmt.Errorf("unable to apply template %s: %s", tmpl.Name(), err)
+		}
+
+		updatedMeta, err := kmeta.Accessor(updated)
+		if err != nil {
+			return fmt.Errorf("unable to get meta accessor for updated object: %s", err)
+		}
+
+		if updatedMeta.GetAnnotations() == nil {
+			updatedMeta.SetAnnotations(map[string]string{})
+		}
+		updatedMeta.GetAnnotations()[checksumAnnotation] = dgst
+
+		err = sdk.Update(updated)
+		*modified = err == nil
+		return err
+	})
+}
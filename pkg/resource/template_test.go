@@ -0,0 +1,45 @@
+package resource
+
+import "testing"
+
+func TestChecksumDeterministic(t *testing.T) {
+	type thing struct {
+		Name  string
+		Value int
+	}
+
+	a, err := checksum(thing{Name: "foo", Value: 1})
+	if err != nil {
+		t.Fatalf("checksum returned an error: %s", err)
+	}
+
+	b, err := checksum(thing{Name: "foo", Value: 1})
+	if err != nil {
+		t.Fatalf("checksum returned an error: %s", err)
+	}
+
+	if a != b {
+		t.Errorf("checksum of identical values differs: %s != %s", a, b)
+	}
+
+	c, err := checksum(thing{Name: "foo", Value: 2})
+	if err != nil {
+		t.Fatalf("checksum returned an error: %s", err)
+	}
+
+	if a == c {
+		t.Errorf("checksum did not change when the input changed: %s", a)
+	}
+}
+
+func TestChecksumPrefixed(t *testing.T) {
+	dgst, err := checksum(map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatalf("checksum returned an error: %s", err)
+	}
+
+	const prefix = "sha256:"
+	if len(dgst) <= len(prefix) || dgst[:len(prefix)] != prefix {
+		t.Errorf("expected checksum to start with %q, got %q", prefix, dgst)
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+type azureDriver struct {
+	namespace string
+	spec      *v1alpha1.AzureStorageDriver
+}
+
+func (d *azureDriver) ValidateConfiguration(configState *ConfigState) error {
+	return validateNameUnchanged("Azure container", configState.Name, d.spec.Container)
+}
+
+func (d *azureDriver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	if d.spec.AccountKey == "" {
+		return nil, nil
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+		StringData: map[string]string{
+			"REGISTRY_STORAGE_AZURE_ACCOUNTNAME": d.spec.AccountName,
+			"REGISTRY_STORAGE_AZURE_ACCOUNTKEY":  d.spec.AccountKey,
+		},
+	}, nil
+}
+
+// defaultAzureAuthorityHost is used for AZURE_AUTHORITY_HOST when the
+// cluster isn't in an Azure sovereign cloud with a different AAD
+// endpoint.
+const defaultAzureAuthorityHost = "https://login.microsoftonline.com/"
+
+func (d *azureDriver) GenerateEnv() ([]corev1.EnvVar, error) {
+	env := []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "azure"},
+		{Name: "REGISTRY_STORAGE_AZURE_CONTAINER", Value: d.spec.Container},
+	}
+
+	if d.spec.ClientID != "" && d.spec.TenantID != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_AZURE_ACCOUNTNAME", Value: d.spec.AccountName},
+			corev1.EnvVar{Name: "AZURE_CLIENT_ID", Value: d.spec.ClientID},
+			corev1.EnvVar{Name: "AZURE_TENANT_ID", Value: d.spec.TenantID},
+			corev1.EnvVar{Name: "AZURE_FEDERATED_TOKEN_FILE", Value: d.tokenFile()},
+			corev1.EnvVar{Name: "AZURE_AUTHORITY_HOST", Value: defaultAzureAuthorityHost},
+		)
+	} else if d.spec.AccountKey != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_AZURE_ACCOUNTNAME", ValueFrom: secretKeyRef("REGISTRY_STORAGE_AZURE_ACCOUNTNAME")},
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_AZURE_ACCOUNTKEY", ValueFrom: secretKeyRef("REGISTRY_STORAGE_AZURE_ACCOUNTKEY")},
+		)
+	}
+
+	return env, nil
+}
+
+func (d *azureDriver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	if d.spec.ClientID == "" || d.spec.TenantID == "" {
+		return nil, nil, nil
+	}
+	vol, mount, _ := projectedServiceAccountTokenVolume("azure-identity-token", d.spec.TokenPath, "api://AzureADTokenExchange")
+	return &vol, &mount, nil
+}
+
+// tokenFile is the path projectedServiceAccountTokenVolume mounts the
+// Azure AD federated token at, recomputed here rather than threaded
+// through a field so GenerateEnv and GenerateVolume can't disagree about
+// it.
+func (d *azureDriver) tokenFile() string {
+	_, _, path := projectedServiceAccountTokenVolume("azure-identity-token", d.spec.TokenPath, "api://AzureADTokenExchange")
+	return path
+}
+
+func (d *azureDriver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	return probeReachable(statusHandler, fmt.Sprintf("https://%s.blob.core.windows.net", d.spec.AccountName))
+}
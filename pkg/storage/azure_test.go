@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+)
+
+func TestAzureDriverGenerateEnvWorkloadIdentity(t *testing.T) {
+	d := &azureDriver{namespace: "openshift-image-registry", spec: &v1alpha1.AzureStorageDriver{
+		Container:   "my-container",
+		AccountName: "myaccount",
+		ClientID:    "11111111-1111-1111-1111-111111111111",
+		TenantID:    "22222222-2222-2222-2222-222222222222",
+	}}
+
+	env, err := d.GenerateEnv()
+	if err != nil {
+		t.Fatalf("GenerateEnv returned an error: %s", err)
+	}
+
+	got := map[string]string{}
+	for _, e := range env {
+		got[e.Name] = e.Value
+	}
+
+	if got["AZURE_CLIENT_ID"] != d.spec.ClientID {
+		t.Errorf("AZURE_CLIENT_ID = %q, want %q", got["AZURE_CLIENT_ID"], d.spec.ClientID)
+	}
+	if got["AZURE_TENANT_ID"] != d.spec.TenantID {
+		t.Errorf("AZURE_TENANT_ID = %q, want %q", got["AZURE_TENANT_ID"], d.spec.TenantID)
+	}
+	if got["AZURE_FEDERATED_TOKEN_FILE"] != d.tokenFile() {
+		t.Errorf("AZURE_FEDERATED_TOKEN_FILE = %q, want %q", got["AZURE_FEDERATED_TOKEN_FILE"], d.tokenFile())
+	}
+	if got["REGISTRY_STORAGE_AZURE_ACCOUNTNAME"] != d.spec.AccountName {
+		t.Errorf("REGISTRY_STORAGE_AZURE_ACCOUNTNAME = %q, want %q", got["REGISTRY_STORAGE_AZURE_ACCOUNTNAME"], d.spec.AccountName)
+	}
+	if _, ok := got["REGISTRY_STORAGE_AZURE_ACCOUNTKEY"]; ok {
+		t.Errorf("workload identity mode must not also set REGISTRY_STORAGE_AZURE_ACCOUNTKEY")
+	}
+
+	vol, mount, err := d.GenerateVolume()
+	if err != nil {
+		t.Fatalf("GenerateVolume returned an error: %s", err)
+	}
+	if vol == nil || mount == nil {
+		t.Fatalf("GenerateVolume must return a projected token volume/mount when ClientID/TenantID are set")
+	}
+}
+
+func TestAzureDriverGenerateEnvStaticKey(t *testing.T) {
+	d := &azureDriver{namespace: "openshift-image-registry", spec: &v1alpha1.AzureStorageDriver{
+		Container:   "my-container",
+		AccountName: "myaccount",
+		AccountKey:  "key",
+	}}
+
+	env, err := d.GenerateEnv()
+	if err != nil {
+		t.Fatalf("GenerateEnv returned an error: %s", err)
+	}
+
+	var accountNameFromValue, accountKeyFromValueFrom bool
+	for _, e := range env {
+		if e.Name == "REGISTRY_STORAGE_AZURE_ACCOUNTNAME" {
+			if e.Value != "" {
+				t.Errorf("static-key mode must source REGISTRY_STORAGE_AZURE_ACCOUNTNAME via ValueFrom, not inline, got Value=%q", e.Value)
+			}
+			accountNameFromValue = e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil
+		}
+		if e.Name == "REGISTRY_STORAGE_AZURE_ACCOUNTKEY" {
+			accountKeyFromValueFrom = e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil
+		}
+		if e.Name == "AZURE_CLIENT_ID" {
+			t.Errorf("static-key mode must not also set AZURE_CLIENT_ID")
+		}
+	}
+	if !accountNameFromValue {
+		t.Errorf("expected REGISTRY_STORAGE_AZURE_ACCOUNTNAME sourced via ValueFrom")
+	}
+	if !accountKeyFromValueFrom {
+		t.Errorf("expected REGISTRY_STORAGE_AZURE_ACCOUNTKEY sourced via ValueFrom")
+	}
+
+	vol, mount, err := d.GenerateVolume()
+	if err != nil {
+		t.Fatalf("GenerateVolume returned an error: %s", err)
+	}
+	if vol != nil || mount != nil {
+		t.Errorf("GenerateVolume must return nil, nil for static-key credentials, got %+v, %+v", vol, mount)
+	}
+}
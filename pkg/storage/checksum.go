@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Checksum returns a stable digest of secretName's current contents in
+// namespace, so a Deployment/DeploymentConfig pod template can carry it
+// as an annotation and roll out whenever the storage credentials Secret
+// rotates - the Secret is only ever referenced from the pod spec via
+// ValueFrom, so nothing about a rotation otherwise changes the checksum
+// ApplyTemplate's short-circuit depends on. A Secret that doesn't exist
+// (e.g. S3 credentials supplied out of band via an instance profile)
+// checksums to the same value every time, which is fine: there's nothing
+// to roll out for.
+func Checksum(namespace string) (string, error) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace}}
+	if err := sdk.Get(secret); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable to get storage secret %s: %s", secretName, err)
+	}
+
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write(secret.Data[key])
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+// Package storage turns the CR's Spec.Storage into a concrete backend:
+// it knows how to validate the configuration, generate the credentials
+// Secret and the env/volume wiring the registry Deployment needs, and
+// periodically probe that the backing store is actually reachable.
+package storage
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+// ConfigState is the storage configuration persisted across reconciles
+// (see resource.GetConfigState/SetConfigState in the newer generator) so
+// that ValidateConfiguration can reject a change that would orphan data
+// already written under the old configuration, such as a bucket rename.
+type ConfigState struct {
+	StorageType string
+	Name        string // bucket, container, share, or path, depending on the driver
+}
+
+// Driver is implemented by every storage backend the operator can wire
+// the registry Deployment up to.
+type Driver interface {
+	// ValidateConfiguration rejects a CR that tries to move the storage
+	// driver, bucket, or container in a way that would orphan existing
+	// data, comparing the desired Spec.Storage against configState (the
+	// configuration persisted from the last successful reconcile).
+	ValidateConfiguration(configState *ConfigState) error
+
+	// GenerateSecret returns the Secret holding this driver's
+	// credentials, or nil if it doesn't need one (e.g. filesystem).
+	GenerateSecret(namespace string) (*corev1.Secret, error)
+
+	// GenerateEnv contributes this driver's env vars to the registry
+	// container, referencing the Secret from GenerateSecret via
+	// ValueFrom rather than inlining secret values.
+	GenerateEnv() ([]corev1.EnvVar, error)
+
+	// GenerateVolume contributes a volume/mount pair the driver needs
+	// (e.g. the PVC for the filesystem driver), or nil, nil if none.
+	GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error)
+
+	// Probe verifies the backing store is reachable right now and
+	// reports the result as a StorageAvailable condition.
+	Probe(statusHandler *clusteroperator.StatusHandler) error
+}
+
+// NewDriver picks the Driver implied by spec, rejecting a CR that
+// configures more than one backend at once.
+func NewDriver(crName, namespace string, spec *v1alpha1.ImageRegistryConfigStorage) (Driver, error) {
+	var (
+		driver     Driver
+		configured int
+	)
+
+	if spec.Filesystem != nil {
+		driver = &filesystemDriver{crName: crName, namespace: namespace, spec: spec.Filesystem}
+		configured++
+	}
+	if spec.S3 != nil {
+		driver = &s3Driver{namespace: namespace, spec: spec.S3}
+		configured++
+	}
+	if spec.GCS != nil {
+		driver = &gcsDriver{namespace: namespace, spec: spec.GCS}
+		configured++
+	}
+	if spec.Azure != nil {
+		driver = &azureDriver{namespace: namespace, spec: spec.Azure}
+		configured++
+	}
+	if spec.Swift != nil {
+		driver = &swiftDriver{namespace: namespace, spec: spec.Swift}
+		configured++
+	}
+	if spec.EmptyDir != nil {
+		driver = &emptyDirDriver{}
+		configured++
+	}
+
+	switch configured {
+	case 0:
+		return nil, fmt.Errorf("no storage backend is configured")
+	case 1:
+		return driver, nil
+	default:
+		return nil, fmt.Errorf("it is not possible to initialize more than one storage backend at the same time")
+	}
+}
+
+// secretName is the Secret every driver that needs credentials writes
+// to and reads env vars from, matching the name PodTemplateSpec already
+// expects in pkg/generate.
+const secretName = "image-registry-private-configuration"
+
+func validateNameUnchanged(kind, configuredName, desiredName string) error {
+	if configuredName != "" && configuredName != desiredName {
+		return fmt.Errorf("changing the %s after the registry has written data is not supported (was %q, now %q)", kind, configuredName, desiredName)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	osapi "github.com/openshift/cluster-version-operator/pkg/apis/operatorstatus.openshift.io/v1"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+// emptyDirDriver backs the registry with an emptyDir, which is only
+// appropriate for non-HA, throwaway deployments (CI, demos): data does
+// not survive a pod restart and is never shared across replicas.
+type emptyDirDriver struct{}
+
+func (d *emptyDirDriver) ValidateConfiguration(configState *ConfigState) error {
+	return nil
+}
+
+func (d *emptyDirDriver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	return nil, nil
+}
+
+func (d *emptyDirDriver) GenerateEnv() ([]corev1.EnvVar, error) {
+	return []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "filesystem"},
+		{Name: "REGISTRY_STORAGE_FILESYSTEM_ROOTDIRECTORY", Value: "/registry"},
+	}, nil
+}
+
+func (d *emptyDirDriver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	vol := &corev1.Volume{
+		Name:         "registry-storage",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+	mount := &corev1.VolumeMount{Name: vol.Name, MountPath: "/registry"}
+	return vol, mount, nil
+}
+
+func (d *emptyDirDriver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	return statusHandler.Update(StorageAvailable, osapi.ConditionTrue, "emptyDir storage has no separate reachability check")
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	osapi "github.com/openshift/cluster-version-operator/pkg/apis/operatorstatus.openshift.io/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+type filesystemDriver struct {
+	crName    string
+	namespace string
+	spec      *v1alpha1.FilesystemStorageDriver
+}
+
+func (d *filesystemDriver) ValidateConfiguration(configState *ConfigState) error {
+	if d.spec.VolumeSource.HostPath != nil {
+		return fmt.Errorf("HostPath is not supported")
+	}
+	return nil
+}
+
+func (d *filesystemDriver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	return nil, nil
+}
+
+func (d *filesystemDriver) GenerateEnv() ([]corev1.EnvVar, error) {
+	return []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "filesystem"},
+		{Name: "REGISTRY_STORAGE_FILESYSTEM_ROOTDIRECTORY", Value: "/registry"},
+	}, nil
+}
+
+func (d *filesystemDriver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	vol := &corev1.Volume{
+		Name:         "registry-storage",
+		VolumeSource: d.spec.VolumeSource,
+	}
+	mount := &corev1.VolumeMount{Name: vol.Name, MountPath: "/registry"}
+	return vol, mount, nil
+}
+
+// Probe can't check reachability of a PVC/emptyDir the way it can an
+// object-storage endpoint: the volume is only proven out once it is
+// actually mounted by the registry pod, which kubelet already reports
+// through the Deployment's own readiness.
+func (d *filesystemDriver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	return statusHandler.Update(StorageAvailable, osapi.ConditionTrue, "filesystem storage has no separate reachability check")
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+type gcsDriver struct {
+	namespace string
+	spec      *v1alpha1.GCSStorageDriver
+}
+
+func (d *gcsDriver) ValidateConfiguration(configState *ConfigState) error {
+	return validateNameUnchanged("GCS bucket", configState.Name, d.spec.Bucket)
+}
+
+func (d *gcsDriver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	return nil, nil // GCS credentials come from the node's service account or workload identity
+}
+
+func (d *gcsDriver) GenerateEnv() ([]corev1.EnvVar, error) {
+	return []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "gcs"},
+		{Name: "REGISTRY_STORAGE_GCS_BUCKET", Value: d.spec.Bucket},
+	}, nil
+}
+
+func (d *gcsDriver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	return nil, nil, nil
+}
+
+func (d *gcsDriver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	return probeReachable(statusHandler, "https://storage.googleapis.com")
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// workloadIdentityTokenExpirationSeconds is the kubelet's refresh
+// interval for a projected service account token used as a web-identity
+// credential; 3600s matches what AWS STS/AssumeRoleWithWebIdentity and
+// Azure AD's token exchange both expect.
+const workloadIdentityTokenExpirationSeconds = int64(3600)
+
+// projectedServiceAccountTokenVolume builds a projected service account
+// token volume (and its mount) for cloud-provider workload-identity
+// federation: the kubelet refreshes the token for the given audience
+// roughly every hour, and the cloud SDK inside the container reads it
+// straight off disk instead of a static credential.
+func projectedServiceAccountTokenVolume(volumeName, path, audience string) (corev1.Volume, corev1.VolumeMount, string) {
+	if path == "" {
+		path = fmt.Sprintf("/var/run/secrets/%s/serviceaccount/token", volumeName)
+	}
+
+	expiration := workloadIdentityTokenExpirationSeconds
+	vol := corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          audience,
+							ExpirationSeconds: &expiration,
+							Path:              filepath.Base(path),
+						},
+					},
+				},
+			},
+		},
+	}
+	mount := corev1.VolumeMount{Name: volumeName, MountPath: filepath.Dir(path), ReadOnly: true}
+
+	return vol, mount, path
+}
@@ -0,0 +1,45 @@
+package storage
+
+import "testing"
+
+func TestProjectedServiceAccountTokenVolumeDefaultPath(t *testing.T) {
+	vol, mount, path := projectedServiceAccountTokenVolume("aws-iam-token", "", "sts.amazonaws.com")
+
+	const wantPath = "/var/run/secrets/aws-iam-token/serviceaccount/token"
+	if path != wantPath {
+		t.Errorf("path = %q, want %q", path, wantPath)
+	}
+
+	proj := vol.VolumeSource.Projected
+	if proj == nil || len(proj.Sources) != 1 || proj.Sources[0].ServiceAccountToken == nil {
+		t.Fatalf("volume does not project a single ServiceAccountToken source: %+v", vol)
+	}
+	sat := proj.Sources[0].ServiceAccountToken
+	if sat.Audience != "sts.amazonaws.com" {
+		t.Errorf("audience = %q, want %q", sat.Audience, "sts.amazonaws.com")
+	}
+	if sat.ExpirationSeconds == nil || *sat.ExpirationSeconds != workloadIdentityTokenExpirationSeconds {
+		t.Errorf("ExpirationSeconds = %v, want %d", sat.ExpirationSeconds, workloadIdentityTokenExpirationSeconds)
+	}
+	if sat.Path != "token" {
+		t.Errorf("projected token file name = %q, want %q", sat.Path, "token")
+	}
+
+	if mount.MountPath != "/var/run/secrets/aws-iam-token/serviceaccount" {
+		t.Errorf("mount path = %q, want the token's parent directory", mount.MountPath)
+	}
+	if !mount.ReadOnly {
+		t.Errorf("token mount should be read-only")
+	}
+}
+
+func TestProjectedServiceAccountTokenVolumeCustomPath(t *testing.T) {
+	_, mount, path := projectedServiceAccountTokenVolume("azure-identity-token", "/var/run/secrets/azure/token", "api://AzureADTokenExchange")
+
+	if path != "/var/run/secrets/azure/token" {
+		t.Errorf("path = %q, want the caller-supplied path unchanged", path)
+	}
+	if mount.MountPath != "/var/run/secrets/azure" {
+		t.Errorf("mount path = %q, want %q", mount.MountPath, "/var/run/secrets/azure")
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	osapi "github.com/openshift/cluster-version-operator/pkg/apis/operatorstatus.openshift.io/v1"
+
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+// StorageAvailable is the condition every driver's Probe reports,
+// alongside the fixed set (OperatorAvailable/OperatorProgressing/OperatorFailing)
+// that clusteroperator.StatusHandler already manages for the Deployment.
+const StorageAvailable osapi.ClusterStatusConditionType = "StorageAvailable"
+
+const probeTimeout = 5 * time.Second
+
+// probeReachable does a best-effort TCP dial against rawurl's host:port
+// and reports the outcome as the StorageAvailable condition. It is not a
+// substitute for a backend-specific health check (e.g. HeadBucket), but
+// it catches the common failure mode of a storage endpoint that is
+// simply unreachable from the cluster's network.
+func probeReachable(statusHandler *clusteroperator.StatusHandler, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("unable to parse storage endpoint %q: %s", rawurl, err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "https":
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		default:
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, dialErr := net.DialTimeout("tcp", addr, probeTimeout)
+	if conn != nil {
+		conn.Close()
+	}
+
+	if dialErr != nil {
+		if err := statusHandler.Update(StorageAvailable, osapi.ConditionFalse, fmt.Sprintf("unable to reach storage endpoint %s: %s", addr, dialErr)); err != nil {
+			return fmt.Errorf("unable to reach storage endpoint %s, and unable to update status: %s, %s", addr, dialErr, err)
+		}
+		return fmt.Errorf("unable to reach storage endpoint %s: %s", addr, dialErr)
+	}
+
+	return statusHandler.Update(StorageAvailable, osapi.ConditionTrue, fmt.Sprintf("storage endpoint %s is reachable", addr))
+}
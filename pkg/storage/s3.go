@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+type s3Driver struct {
+	namespace string
+	spec      *v1alpha1.S3StorageDriver
+}
+
+func (d *s3Driver) ValidateConfiguration(configState *ConfigState) error {
+	return validateNameUnchanged("S3 bucket", configState.Name, d.spec.Bucket)
+}
+
+func (d *s3Driver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	if d.spec.AccessKey == "" && d.spec.SecretKey == "" {
+		return nil, nil // credentials supplied out of band, e.g. an instance profile
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+		StringData: map[string]string{
+			"REGISTRY_STORAGE_S3_ACCESSKEY": d.spec.AccessKey,
+			"REGISTRY_STORAGE_S3_SECRETKEY": d.spec.SecretKey,
+		},
+	}, nil
+}
+
+func (d *s3Driver) GenerateEnv() ([]corev1.EnvVar, error) {
+	env := []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "s3"},
+		{Name: "REGISTRY_STORAGE_S3_BUCKET", Value: d.spec.Bucket},
+		{Name: "REGISTRY_STORAGE_S3_REGION", Value: d.spec.Region},
+		{Name: "REGISTRY_STORAGE_S3_REGIONENDPOINT", Value: d.spec.RegionEndpoint},
+		{Name: "REGISTRY_STORAGE_S3_ENCRYPT", Value: fmt.Sprintf("%v", d.spec.Encrypt)},
+	}
+
+	if d.spec.RoleARN != "" {
+		// The docker/distribution S3 driver picks up credentials from the
+		// AWS SDK's web-identity chain once AWS_ROLE_ARN/
+		// AWS_WEB_IDENTITY_TOKEN_FILE are set - no static key env vars at
+		// all, so there's nothing here for GenerateVolume's caller to mount
+		// a secret for.
+		env = append(env,
+			corev1.EnvVar{Name: "AWS_ROLE_ARN", Value: d.spec.RoleARN},
+			corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE", Value: d.tokenFile()},
+			corev1.EnvVar{Name: "AWS_REGION", Value: d.spec.Region},
+		)
+	} else if d.spec.AccessKey != "" || d.spec.SecretKey != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_S3_ACCESSKEY", ValueFrom: secretKeyRef("REGISTRY_STORAGE_S3_ACCESSKEY")},
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_S3_SECRETKEY", ValueFrom: secretKeyRef("REGISTRY_STORAGE_S3_SECRETKEY")},
+		)
+	}
+
+	return env, nil
+}
+
+func (d *s3Driver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	if d.spec.RoleARN == "" {
+		return nil, nil, nil
+	}
+	vol, mount, _ := projectedServiceAccountTokenVolume("aws-iam-token", d.spec.TokenPath, "sts.amazonaws.com")
+	return &vol, &mount, nil
+}
+
+// tokenFile is the path projectedServiceAccountTokenVolume mounts the STS
+// web-identity token at, recomputed here rather than threaded through a
+// field so GenerateEnv and GenerateVolume can't disagree about it.
+func (d *s3Driver) tokenFile() string {
+	_, _, path := projectedServiceAccountTokenVolume("aws-iam-token", d.spec.TokenPath, "sts.amazonaws.com")
+	return path
+}
+
+func (d *s3Driver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	endpoint := d.spec.RegionEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", d.spec.Region)
+	}
+	return probeReachable(statusHandler, endpoint)
+}
+
+func secretKeyRef(key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+			Key:                  key,
+		},
+	}
+}
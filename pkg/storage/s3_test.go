@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+)
+
+func TestS3DriverGenerateEnvWorkloadIdentity(t *testing.T) {
+	d := &s3Driver{namespace: "openshift-image-registry", spec: &v1alpha1.S3StorageDriver{
+		Bucket:  "my-bucket",
+		Region:  "us-east-1",
+		RoleARN: "arn:aws:iam::123456789012:role/registry",
+	}}
+
+	env, err := d.GenerateEnv()
+	if err != nil {
+		t.Fatalf("GenerateEnv returned an error: %s", err)
+	}
+
+	got := map[string]string{}
+	for _, e := range env {
+		got[e.Name] = e.Value
+	}
+
+	if got["AWS_ROLE_ARN"] != d.spec.RoleARN {
+		t.Errorf("AWS_ROLE_ARN = %q, want %q", got["AWS_ROLE_ARN"], d.spec.RoleARN)
+	}
+	if got["AWS_REGION"] != "us-east-1" {
+		t.Errorf("AWS_REGION = %q, want %q", got["AWS_REGION"], "us-east-1")
+	}
+	if got["AWS_WEB_IDENTITY_TOKEN_FILE"] != d.tokenFile() {
+		t.Errorf("AWS_WEB_IDENTITY_TOKEN_FILE = %q, want %q", got["AWS_WEB_IDENTITY_TOKEN_FILE"], d.tokenFile())
+	}
+	for _, name := range []string{"REGISTRY_STORAGE_S3_ACCESSKEY", "REGISTRY_STORAGE_S3_SECRETKEY"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("workload identity mode must not also set static-key env var %s", name)
+		}
+	}
+
+	vol, mount, err := d.GenerateVolume()
+	if err != nil {
+		t.Fatalf("GenerateVolume returned an error: %s", err)
+	}
+	if vol == nil || mount == nil {
+		t.Fatalf("GenerateVolume must return a projected token volume/mount when RoleARN is set")
+	}
+}
+
+func TestS3DriverGenerateEnvStaticKeys(t *testing.T) {
+	d := &s3Driver{namespace: "openshift-image-registry", spec: &v1alpha1.S3StorageDriver{
+		Bucket:    "my-bucket",
+		Region:    "us-east-1",
+		AccessKey: "AKIA...",
+		SecretKey: "secret",
+	}}
+
+	env, err := d.GenerateEnv()
+	if err != nil {
+		t.Fatalf("GenerateEnv returned an error: %s", err)
+	}
+
+	got := map[string]bool{}
+	for _, e := range env {
+		got[e.Name] = true
+		if e.Name == "REGISTRY_STORAGE_S3_ACCESSKEY" || e.Name == "REGISTRY_STORAGE_S3_SECRETKEY" {
+			if e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil {
+				t.Errorf("%s must be sourced via ValueFrom, not inlined", e.Name)
+			}
+		}
+	}
+	if got["AWS_ROLE_ARN"] {
+		t.Errorf("static-key mode must not also set AWS_ROLE_ARN")
+	}
+
+	vol, mount, err := d.GenerateVolume()
+	if err != nil {
+		t.Fatalf("GenerateVolume returned an error: %s", err)
+	}
+	if vol != nil || mount != nil {
+		t.Errorf("GenerateVolume must return nil, nil for static-key credentials, got %+v, %+v", vol, mount)
+	}
+}
+
+func TestValidateNameUnchanged(t *testing.T) {
+	d := &s3Driver{spec: &v1alpha1.S3StorageDriver{Bucket: "new-bucket"}}
+
+	if err := d.ValidateConfiguration(&ConfigState{Name: ""}); err != nil {
+		t.Errorf("first-ever configuration must be allowed, got error: %s", err)
+	}
+	if err := d.ValidateConfiguration(&ConfigState{Name: "new-bucket"}); err != nil {
+		t.Errorf("unchanged bucket must be allowed, got error: %s", err)
+	}
+	if err := d.ValidateConfiguration(&ConfigState{Name: "old-bucket"}); err == nil {
+		t.Errorf("renaming the bucket after data has been written must be rejected")
+	}
+}
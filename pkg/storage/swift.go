@@ -0,0 +1,60 @@
+package storage
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1alpha1 "github.com/openshift/cluster-image-registry-operator/pkg/apis/imageregistry/v1alpha1"
+	"github.com/openshift/cluster-image-registry-operator/pkg/clusteroperator"
+)
+
+type swiftDriver struct {
+	namespace string
+	spec      *v1alpha1.SwiftStorageDriver
+}
+
+func (d *swiftDriver) ValidateConfiguration(configState *ConfigState) error {
+	return validateNameUnchanged("Swift container", configState.Name, d.spec.Container)
+}
+
+func (d *swiftDriver) GenerateSecret(namespace string) (*corev1.Secret, error) {
+	if d.spec.Username == "" && d.spec.Password == "" {
+		return nil, nil
+	}
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+		StringData: map[string]string{
+			"REGISTRY_STORAGE_SWIFT_USERNAME": d.spec.Username,
+			"REGISTRY_STORAGE_SWIFT_PASSWORD": d.spec.Password,
+		},
+	}, nil
+}
+
+func (d *swiftDriver) GenerateEnv() ([]corev1.EnvVar, error) {
+	env := []corev1.EnvVar{
+		{Name: "REGISTRY_STORAGE", Value: "swift"},
+		{Name: "REGISTRY_STORAGE_SWIFT_AUTHURL", Value: d.spec.AuthURL},
+		{Name: "REGISTRY_STORAGE_SWIFT_CONTAINER", Value: d.spec.Container},
+	}
+
+	if d.spec.Username != "" || d.spec.Password != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_SWIFT_USERNAME", ValueFrom: secretKeyRef("REGISTRY_STORAGE_SWIFT_USERNAME")},
+			corev1.EnvVar{Name: "REGISTRY_STORAGE_SWIFT_PASSWORD", ValueFrom: secretKeyRef("REGISTRY_STORAGE_SWIFT_PASSWORD")},
+		)
+	}
+
+	return env, nil
+}
+
+func (d *swiftDriver) GenerateVolume() (*corev1.Volume, *corev1.VolumeMount, error) {
+	return nil, nil, nil
+}
+
+func (d *swiftDriver) Probe(statusHandler *clusteroperator.StatusHandler) error {
+	return probeReachable(statusHandler, d.spec.AuthURL)
+}